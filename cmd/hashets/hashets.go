@@ -6,12 +6,16 @@ import (
 	"crypto/md5" //nolint:gosec
 	"crypto/sha256"
 	"crypto/sha512"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"hash"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strings"
 
 	"github.com/bmatcuk/doublestar"
 
@@ -23,12 +27,31 @@ var (
 	//
 	// FLAGS
 
-	hashingAlgorithm hash.Hash
+	hashingAlgorithm func() hash.Hash
+	concurrency      int
 	ignore           []string
 	include          []string
 	replace          bool
+	passThrough      bool
 	outPath          string
 	fileNamesVar     string
+	rewriters        []hashets.Rewriter
+	extractors       []hashets.ReferenceExtractor
+	urlPrefix        string
+	importMapPath    string
+	sriAlgorithm     hashets.SRIAlgorithm
+	sriVar           string
+	manifestPath     string
+	history          int
+	gc               bool
+	keep             int
+	manifestFormat   hashets.ManifestFormat
+	naming           hashets.NamingScheme
+	query            []string
+	encoders         []hashets.Encoder
+	gzip             bool
+	gzipLevel        int
+	compressMinSize  int64
 
 	//
 	// ARGS.
@@ -41,8 +64,27 @@ var (
 	packageName string // GOPACKAGE
 )
 
+// namingSchemes maps the names accepted by -naming to the corresponding
+// hashets.NamingScheme.
+var namingSchemes = map[string]hashets.NamingScheme{
+	"underscore": hashets.UnderscoreNaming{},
+	"dot":        hashets.DotNaming{},
+	"dir":        hashets.DirNaming{},
+	"root-dir":   hashets.DirNaming{Root: true},
+	"content":    hashets.ContentAddressedNaming{},
+	"query":      hashets.QueryNaming{},
+}
+
+// rewriterTypes maps the types accepted by -rewrite to functions creating the
+// corresponding hashets.Rewriter, given the value of -prefix.
+var rewriterTypes = map[string]func(prefix string) hashets.Rewriter{
+	"css": func(prefix string) hashets.Rewriter { return hashets.CSSRewriter{Prefix: prefix} },
+	"js":  func(prefix string) hashets.Rewriter { return hashets.JSRewriter{Prefix: prefix} },
+}
+
 func init() {
 	hashingAlgoStr := flag.String("hash", "sha256", "hashing algorithm to use (sha256, sha512, md5)")
+	flag.IntVar(&concurrency, "concurrency", runtime.GOMAXPROCS(0), "maximum number of files to hash concurrently")
 	flag.Func("ignore",
 		"ignores paths that match the glob\n"+
 			"supports ** globs",
@@ -68,25 +110,137 @@ func init() {
 			include = append(include, s)
 			return nil
 		})
+	var rewriteTypes []string
+	flag.Func("rewrite",
+		"rewrites references to hashed files in files of the given type before hashing them\n"+
+			"can be repeated, supported types: css, js",
+		func(s string) error {
+			if _, ok := rewriterTypes[s]; !ok {
+				return fmt.Errorf("unsupported type: %s", s)
+			}
+
+			rewriteTypes = append(rewriteTypes, s)
+			return nil
+		})
+	var referenceTypes []string
+	flag.Func("references",
+		"folds the hashes of the files referenced by files of the given type into their hashes,\n"+
+			"without rewriting them, can be repeated, supported types: css, js",
+		func(s string) error {
+			if _, ok := rewriterTypes[s]; !ok {
+				return fmt.Errorf("unsupported type: %s", s)
+			}
+
+			referenceTypes = append(referenceTypes, s)
+			return nil
+		})
+	flag.StringVar(&urlPrefix, "prefix", "",
+		"the URL path DIR is served under (default /)\n"+
+			"used by -rewrite and -references to resolve absolute URLs, and by -importmap")
+	flag.StringVar(&importMapPath, "importmap", "",
+		"additionally write an import map for the hashed JavaScript modules to the given file,\n"+
+			"using -prefix as the URL path of DIR")
 	flag.BoolVar(&replace, "replace", false, "delete the original original files after hashing")
+	flag.BoolVar(&passThrough, "passthrough", false,
+		"include the ignored files in the generated map, mapped to themselves")
+	flag.IntVar(&history, "history", 0,
+		"record the hashed files in the history in -o, keeping the given number of generations,\n"+
+			"so that servers can continue to serve them after they became stale, see hashets.ReadHistory")
+	flag.BoolVar(&gc, "gc", false,
+		"after hashing, remove the hashed files in -o that are stale, i.e. left over from previous runs")
+	flag.IntVar(&keep, "keep", 0,
+		"number of previous versions of each file that -gc keeps, in addition to those in the history")
+	flag.Func("naming",
+		"naming scheme of the hashed files (default underscore):\n"+
+			"underscore (foo_1234.txt), dot (foo.1234.txt), dir (1234/foo.txt),\n"+
+			"root-dir (1234/dir/foo.txt), content (12/34.txt, content-addressed),\n"+
+			"or query (foo.txt?v=1234, for files whose names cannot change)",
+		func(s string) error {
+			n, ok := namingSchemes[s]
+			if !ok {
+				return fmt.Errorf("unknown naming scheme: %s", s)
+			}
+
+			naming = n
+			return nil
+		})
+	flag.Func("query",
+		"hashes paths that match the glob using the query naming scheme (foo.txt?v=1234),\n"+
+			"e.g. favicon.ico, and all other files using -naming\n"+
+			"can be repeated, supports ** globs",
+		func(s string) error {
+			_, err := doublestar.PathMatch(s, "")
+			if err != nil {
+				return err
+			}
+
+			query = append(query, s)
+			return nil
+		})
+	flag.Func("compress",
+		"additionally write precompressed variants of the compressible hashed files using the given\n"+
+			"comma-separated encoders, e.g. gzip for foo_1234.txt.gz;\n"+
+			"only the gzip encoder is available, use hashets.HashToDir for other encoders",
+		func(s string) error {
+			for _, name := range strings.Split(s, ",") {
+				enc, ok := hashets.LookupEncoder(strings.TrimSpace(name))
+				if !ok {
+					return fmt.Errorf("unknown encoder: %s (available: %s)", name, encoderNames())
+				}
+
+				encoders = append(encoders, enc)
+			}
+
+			return nil
+		})
+	flag.BoolVar(&gzip, "gzip", false, "shorthand for -compress gzip")
+	flag.IntVar(&gzipLevel, "gzip-level", 0, "compression level of the gzip variants, from 1 to 9 (default 6);\n"+
+		"it does not apply to other encoders")
+	flag.Int64Var(&compressMinSize, "compress-min-size", 0,
+		"minimum size in bytes of the files precompressed by any of the encoders of -gzip and -compress")
 	flag.StringVar(&outPath, "o", "", "output directory (default DIR)")
 	flag.StringVar(&fileNamesVar, "var", "FileNames", "name of the variable in hashets_map.go")
+	flag.Func("sri",
+		"additionally compute the Subresource Integrity strings of the hashed files using the\n"+
+			"given algorithm (sha256, sha384, sha512), and write them to hashets_map.go",
+		func(s string) error {
+			switch alg := hashets.SRIAlgorithm(s); alg {
+			case hashets.SRISHA256, hashets.SRISHA384, hashets.SRISHA512:
+				sriAlgorithm = alg
+				return nil
+			default:
+				return fmt.Errorf("unsupported algorithm: %s", s)
+			}
+		})
+	flag.StringVar(&sriVar, "sri-var", "Integrity", "name of the hashets.SRIMap variable in hashets_map.go")
+	flag.StringVar(&manifestPath, "manifest", "",
+		"additionally write a JSON manifest of the hashed files to the given file, using -format")
+	flag.TextVar(&manifestFormat, "format", hashets.FormatHashets,
+		"format of the manifest written by -manifest (hashets, vite, webpack)")
 
 	flag.CommandLine.Usage = usage
 	flag.Parse()
 
 	switch *hashingAlgoStr {
 	case "sha256":
-		hashingAlgorithm = sha256.New()
+		hashingAlgorithm = sha256.New
 	case "sha512":
-		hashingAlgorithm = sha512.New()
+		hashingAlgorithm = sha512.New
 	case "md5":
-		hashingAlgorithm = md5.New() //nolint:gosec
+		hashingAlgorithm = md5.New //nolint:gosec
 	default:
 		fmt.Fprintln(os.Stderr, "invalid hashing algorithm:", *hashingAlgoStr)
 		os.Exit(1)
 	}
 
+	for _, typ := range rewriteTypes {
+		rewriters = append(rewriters, rewriterTypes[typ](urlPrefix))
+	}
+
+	for _, typ := range referenceTypes {
+		extractors = append(extractors, rewriterTypes[typ](urlPrefix))
+	}
+
 	if len(flag.Args()) != 1 {
 		flag.CommandLine.Usage()
 		os.Exit(1)
@@ -145,11 +299,27 @@ func usage() {
 }
 
 func main() {
-	m, err := hashets.HashToDir(os.DirFS(inPath), outPath, hashets.Options{
-		Hash: hashingAlgorithm,
+	// generated are the paths of the files in DIR written by hashets, which
+	// must not be hashed by the next run
+	generated := []string{"hashets_map.go"}
+	if p, ok := pathInDir(inPath, manifestPath); ok {
+		generated = append(generated, p)
+	}
+	if p, ok := pathInDir(inPath, importMapPath); ok {
+		generated = append(generated, p)
+	}
+
+	o := hashets.Options{
+		NewHash:     hashingAlgorithm,
+		Naming:      naming,
+		Concurrency: concurrency,
+		Extractors:  extractors,
+		Rewriters:   rewriters,
 		Ignore: func(p string) bool {
-			if p == "hashets_map.go" {
-				return true
+			for _, g := range generated {
+				if p == g {
+					return true
+				}
 			}
 
 			for _, pattern := range ignore {
@@ -172,25 +342,142 @@ func main() {
 
 			return true
 		},
-	})
+		SRI:         sriAlgorithm,
+		PassThrough: passThrough,
+	}
+
+	if len(query) > 0 {
+		o.Naming = hashets.MatchNaming{
+			Match: func(p string) bool {
+				for _, pattern := range query {
+					if match, _ := doublestar.PathMatch(pattern, filepath.Clean(p)); match {
+						return true
+					}
+				}
+
+				return false
+			},
+			Matched: hashets.QueryNaming{},
+			Default: naming,
+		}
+	}
+
+	if gzip {
+		encoders = append(encoders, hashets.GzipEncoder{})
+	}
+
+	if len(encoders) > 0 {
+		o.Compression = &hashets.CompressionOptions{Encoders: compressionEncoders(), MinSize: compressMinSize}
+	}
+
+	man, err := hashets.HashToDirManifest(os.DirFS(inPath), outPath, o)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "failed to hash files:", err)
 		os.Exit(1)
 	}
 
+	// the generated files are always ignored, even if the ignored files are
+	// passed through
+	for _, p := range generated {
+		delete(man, p)
+	}
+
+	m := man.Map()
+
+	var sri hashets.SRIMap
+	if sriAlgorithm != "" {
+		sri = man.SRI()
+	}
+
 	if replace {
-		for origName := range m {
-			if err := os.Remove(filepath.Join(outPath, origName)); err != nil {
+		for origName, hashedName := range m {
+			// files hashed using the query naming scheme keep their name
+			if p, _, _ := strings.Cut(hashedName, "?"); p == origName {
+				continue
+			}
+
+			// the original may have been replaced by a previous run already
+			err := os.Remove(filepath.Join(outPath, origName))
+			if err != nil && !os.IsNotExist(err) {
 				fmt.Fprintln(os.Stderr, "replace: failed to remove original file:", err)
 				os.Exit(1)
 			}
 		}
 	}
 
+	if history > 0 {
+		if err := hashets.AppendHistory(outPath, man, history); err != nil {
+			fmt.Fprintln(os.Stderr, "history: failed to update history:", err)
+			os.Exit(1)
+		}
+	}
+
+	if gc {
+		if _, err := hashets.RemoveStale(outPath, m, keep, o); err != nil {
+			fmt.Fprintln(os.Stderr, "gc: failed to remove stale files:", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := writeMapFile(m, sri); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to write map file:", err)
+		os.Exit(1)
+	}
+
+	if manifestPath != "" {
+		if err := writeManifest(man); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to write manifest:", err)
+			os.Exit(1)
+		}
+	}
+
+	if importMapPath != "" {
+		im := hashets.NewImportMap(m, hashets.ImportMapOptions{Prefix: urlPrefix})
+
+		data, err := json.MarshalIndent(im, "", "\t")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to encode import map:", err)
+			os.Exit(1)
+		}
+
+		if err := os.WriteFile(importMapPath, append(data, '\n'), 0o644); err != nil { //nolint:gosec
+			fmt.Fprintln(os.Stderr, "failed to write import map:", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// pathInDir returns the slash-separated path of the file at p relative to
+// dir, or false, if p is empty or not inside dir.
+func pathInDir(dir, p string) (string, bool) {
+	if p == "" {
+		return "", false
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+
+	absP, err := filepath.Abs(p)
+	if err != nil {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(absDir, absP)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+
+	return filepath.ToSlash(rel), true
+}
+
+// writeMapFile writes hashets_map.go to the output directory.
+// If sri is not nil, it is written as well.
+func writeMapFile(m hashets.Map, sri hashets.SRIMap) error {
 	mapFile, err := os.Create(filepath.Join(outPath, "hashets_map.go"))
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "failed to create map file:", err)
-		os.Exit(1)
+		return err
 	}
 
 	fmt.Fprintln(mapFile, "package", packageName)
@@ -199,18 +486,81 @@ func main() {
 	fmt.Fprintln(mapFile)
 	fmt.Fprintln(mapFile, "// Code generated by hashets. DO NOT EDIT.")
 	fmt.Fprintln(mapFile)
-	fmt.Fprintln(mapFile, "var", fileNamesVar, "= hashets.Map{")
+	writeMapVar(mapFile, fileNamesVar, "hashets.Map", m)
+
+	if sri != nil {
+		fmt.Fprintln(mapFile)
+		writeMapVar(mapFile, sriVar, "hashets.SRIMap", sri)
+	}
+
+	return mapFile.Close()
+}
+
+// writeManifest writes man to manifestPath, using manifestFormat.
+func writeManifest(man hashets.Manifest) error {
+	f, err := os.Create(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	if err := man.WriteJSON(f, manifestFormat); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// compressionEncoders returns the encoders passed using -compress and -gzip,
+// without duplicates, and with -gzip-level applied.
+func compressionEncoders() []hashets.Encoder {
+	encs := make([]hashets.Encoder, 0, len(encoders))
+	seen := make(map[string]struct{}, len(encoders))
+
+	for _, enc := range encoders {
+		if _, ok := seen[enc.Name()]; ok {
+			continue
+		}
+
+		seen[enc.Name()] = struct{}{}
+
+		if _, ok := enc.(hashets.GzipEncoder); ok {
+			enc = hashets.GzipEncoder{Level: gzipLevel}
+		}
+
+		encs = append(encs, enc)
+	}
+
+	return encs
+}
+
+// encoderNames returns the comma-separated names of the registered encoders.
+func encoderNames() string {
+	encs := hashets.Encoders()
+
+	names := make([]string, len(encs))
+	for i, enc := range encs {
+		names[i] = enc.Name()
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// writeMapVar writes a variable declaration of a map of the given type with
+// the contents of m to w.
+func writeMapVar(w io.Writer, name, typ string, m map[string]string) {
+	fmt.Fprintln(w, "var", name, "=", typ+"{")
 
 	// so that two runs of hashets with the same input produce the same output
-	names := make([]string, 0, len(m))
-	for name := range m {
-		names = append(names, name)
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
-	sort.Strings(names)
+	sort.Strings(keys)
 
-	for _, name := range names {
-		fmt.Fprintf(mapFile, "\t%q: %q,\n", name, m[name])
+	for _, k := range keys {
+		fmt.Fprintf(w, "\t%q: %q,\n", k, m[k])
 	}
 
-	fmt.Fprintln(mapFile, "}")
+	fmt.Fprintln(w, "}")
 }
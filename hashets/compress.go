@@ -0,0 +1,258 @@
+package hashets
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"strings"
+)
+
+// CompressionOptions provides configuration options for the precompression
+// of hashed files, see [Options.Compression].
+type CompressionOptions struct {
+	// Match reports whether the file with the passed path and content type
+	// should be compressed.
+	//
+	// Defaults to [IsCompressible].
+	Match func(path, contentType string) bool
+
+	// MinSize is the minimum size in bytes a file must have to be compressed.
+	//
+	// Regardless of MinSize, compressed variants that are not smaller than
+	// the file itself are discarded.
+	//
+	// Defaults to 0.
+	MinSize int64
+
+	// Encoders are the encoders used to write the precompressed variants.
+	// For each encoder, a variant is written, named after the hashed file
+	// with the encoder's suffix appended.
+	//
+	// Defaults to a [GzipEncoder] with Level.
+	Encoders []Encoder
+
+	// Level is the gzip compression level, as accepted by
+	// [gzip.NewWriterLevel].
+	// It is only used if Encoders is not set.
+	//
+	// Defaults to [gzip.DefaultCompression].
+	Level int
+}
+
+func (o *CompressionOptions) setDefaults() {
+	if o.Match == nil {
+		o.Match = IsCompressible
+	}
+
+	if len(o.Encoders) == 0 {
+		o.Encoders = []Encoder{GzipEncoder{Level: o.Level}}
+	}
+}
+
+// validate checks that all encoders can be used, by creating a writer using
+// each of them.
+func (o *CompressionOptions) validate() error {
+	suffixes := make(map[string]struct{}, len(o.Encoders))
+	for _, enc := range o.Encoders {
+		if enc.Suffix() == "" {
+			return fmt.Errorf("encoder %s: empty suffix", enc.Name())
+		}
+
+		if _, ok := suffixes[enc.Suffix()]; ok {
+			return fmt.Errorf("encoder %s: duplicate suffix %s", enc.Name(), enc.Suffix())
+		}
+
+		suffixes[enc.Suffix()] = struct{}{}
+
+		w, err := enc.NewWriter(io.Discard)
+		if err != nil {
+			return fmt.Errorf("encoder %s: %w", enc.Name(), err)
+		}
+
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("encoder %s: %w", enc.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// variantSuffixes returns the suffixes of the precompressed variants of
+// hashed files, i.e. the suffixes of the registered encoders, and of the
+// encoders in o.Compression.
+func (o *Options) variantSuffixes() []string {
+	encs := Encoders()
+	if o.Compression != nil {
+		encs = append(encs, o.Compression.Encoders...)
+	}
+
+	suffixes := make([]string, 0, len(encs))
+	seen := make(map[string]struct{}, len(encs))
+	for _, enc := range encs {
+		if _, ok := seen[enc.Suffix()]; !ok && enc.Suffix() != "" {
+			seen[enc.Suffix()] = struct{}{}
+			suffixes = append(suffixes, enc.Suffix())
+		}
+	}
+
+	return suffixes
+}
+
+// Variant is a precompressed variant of a hashed file.
+type Variant struct {
+	// Encoding is the Content-Encoding of the variant, e.g. "gzip", as
+	// returned by [Encoder.ContentEncoding].
+	Encoding string
+	// Path is the path of the variant, i.e. the path of the hashed file with
+	// a suffix depending on the encoding, e.g. "foo_1234.txt.gz".
+	Path string
+	// Size is the size of the variant in bytes.
+	Size int64
+}
+
+// IsCompressible reports whether files with the passed content type usually
+// benefit from compression.
+//
+// These are text files, JavaScript, JSON, XML, SVG and WebAssembly files, and
+// uncompressed fonts and icons.
+func IsCompressible(_, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	if strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+
+	switch mediaType {
+	case "application/javascript", "application/json", "application/manifest+json", "application/xml",
+		"application/wasm", "font/ttf", "font/otf", "application/vnd.ms-fontobject", "image/x-icon",
+		"image/vnd.microsoft.icon", "image/bmp":
+		return true
+	default:
+		return false
+	}
+}
+
+// isVariant reports whether the file at p is a precompressed variant of a
+// file in hashed, i.e. whether it is the path of such a file with one of the
+// passed suffixes.
+func isVariant(p string, hashed map[string]struct{}, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if trimmed, ok := strings.CutSuffix(p, suffix); ok {
+			if _, ok := hashed[trimmed]; ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// writeVariants writes the precompressed variants of the hashed file at p,
+// which is described by e, and returns them.
+//
+// If keepExisting is true, existing variants are left as is.
+//
+// The paths of the created files are passed to created.
+func writeVariants(
+	p string, e Entry, o *CompressionOptions, keepExisting bool, created func(p string),
+) ([]Variant, error) {
+	if o == nil || e.Size < o.MinSize || !o.Match(e.Path, e.ContentType) {
+		return nil, nil
+	}
+
+	var variants []Variant
+	for _, enc := range o.Encoders {
+		v, ok, err := writeVariant(p, e, enc, keepExisting, created)
+		if err != nil {
+			return variants, err
+		}
+
+		if ok {
+			variants = append(variants, v)
+		}
+	}
+
+	return variants, nil
+}
+
+// writeVariant writes the variant of the hashed file at p encoded using enc.
+// It returns false, if the variant is not smaller than the file itself, and
+// was therefore discarded.
+func writeVariant(
+	p string, e Entry, enc Encoder, keepExisting bool, created func(p string),
+) (_ Variant, ok bool, _ error) {
+	v := Variant{Encoding: enc.ContentEncoding(), Path: filePath(e.HashedPath) + enc.Suffix()}
+	vp := p + enc.Suffix()
+
+	if keepExisting {
+		if stat, err := os.Stat(vp); err == nil {
+			v.Size = stat.Size()
+			return v, true, nil
+		}
+	}
+
+	_, err := os.Lstat(vp)
+	existed := err == nil
+
+	size, err := writeEncoded(vp, p, e, enc)
+	if err != nil || size >= e.Size {
+		_ = os.Remove(vp)
+		return v, false, err
+	}
+
+	if !existed {
+		created(v.Path)
+	}
+
+	v.Size = size
+	return v, true, nil
+}
+
+// writeEncoded writes the contents of the file at src encoded using enc to
+// the file at dst, and returns the size of the written file.
+func writeEncoded(dst, src string, e Entry, enc Encoder) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := createFile(dst, e.Mode)
+	if err != nil {
+		return 0, err
+	}
+
+	var cw countWriter
+	ew, err := enc.NewWriter(io.MultiWriter(out, &cw))
+	if err != nil {
+		_ = out.Close()
+		return 0, err
+	}
+
+	if _, err := io.Copy(ew, in); err != nil {
+		_ = out.Close()
+		return 0, err
+	}
+
+	if err := ew.Close(); err != nil {
+		_ = out.Close()
+		return 0, err
+	}
+
+	return cw.n, out.Close()
+}
+
+// countWriter is an [io.Writer] that counts the bytes written to it.
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,199 @@
+package hashets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashToDir_Compression(t *testing.T) {
+	t.Parallel()
+
+	text := strings.Repeat("hello world\n", 100)
+
+	files := map[string]string{
+		"large.txt":   text,
+		"small.txt":   "hi",
+		"image.png":   text,
+		"random.json": "{}",
+	}
+
+	testCases := []struct {
+		name        string
+		compression *CompressionOptions
+		expect      map[string]bool
+	}{
+		{
+			name:        "disabled",
+			compression: nil,
+			expect:      map[string]bool{},
+		},
+		{
+			name:        "defaults",
+			compression: &CompressionOptions{},
+			// small.txt and random.json are not smaller when compressed,
+			// and image.png does not match
+			expect: map[string]bool{"large.txt": true},
+		},
+		{
+			name:        "min size",
+			compression: &CompressionOptions{MinSize: int64(len(text) + 1)},
+			expect:      map[string]bool{},
+		},
+		{
+			name: "match",
+			compression: &CompressionOptions{
+				Match: func(path, _ string) bool { return path == "image.png" },
+			},
+			expect: map[string]bool{"image.png": true},
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			inDir := t.TempDir()
+			outDir := t.TempDir()
+			writeFiles(t, inDir, files)
+
+			man, err := HashToDirManifest(os.DirFS(inDir), outDir, Options{Compression: c.compression})
+			require.NoError(t, err)
+
+			for p, e := range man {
+				gzPath := filepath.Join(outDir, e.HashedPath+".gz")
+
+				if !c.expect[p] {
+					assert.Empty(t, e.Variants, p)
+					assert.NoFileExists(t, gzPath)
+					continue
+				}
+
+				require.Len(t, e.Variants, 1, p)
+				v := e.Variants[0]
+				assert.Equal(t, Variant{Encoding: "gzip", Path: e.HashedPath + ".gz", Size: v.Size}, v)
+
+				stat, err := os.Stat(gzPath)
+				require.NoError(t, err)
+				assert.Equal(t, stat.Size(), v.Size)
+				assert.Less(t, v.Size, e.Size)
+
+				data, err := os.ReadFile(gzPath)
+				require.NoError(t, err)
+
+				zr, err := gzip.NewReader(bytes.NewReader(data))
+				require.NoError(t, err)
+				decompressed, err := io.ReadAll(zr)
+				require.NoError(t, err)
+				assert.Equal(t, files[p], string(decompressed))
+			}
+		})
+	}
+}
+
+func TestHashToDir_CompressionIdempotent(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": strings.Repeat("a", 1000)})
+
+	o := Options{Compression: &CompressionOptions{}}
+
+	man, err := HashToDirManifest(os.DirFS(dir), dir, o)
+	require.NoError(t, err)
+	require.Len(t, man["a.txt"].Variants, 1)
+
+	before := snapshotDir(t, dir)
+
+	rerun, err := HashToDirManifest(os.DirFS(dir), dir, o)
+	require.NoError(t, err)
+	assert.Equal(t, man, rerun)
+
+	dirsEqual(t, before, os.DirFS(dir))
+}
+
+func TestIsCompressible(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		contentType string
+		expect      bool
+	}{
+		{contentType: "text/css; charset=utf-8", expect: true},
+		{contentType: "text/javascript; charset=utf-8", expect: true},
+		{contentType: "application/json", expect: true},
+		{contentType: "image/svg+xml", expect: true},
+		{contentType: "application/wasm", expect: true},
+		{contentType: "image/png", expect: false},
+		{contentType: "font/woff2", expect: false},
+		{contentType: "", expect: false},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.contentType, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, c.expect, IsCompressible("", c.contentType))
+		})
+	}
+}
+
+func TestHashToDir_Encoders(t *testing.T) {
+	t.Parallel()
+
+	inDir := t.TempDir()
+	outDir := t.TempDir()
+	writeFiles(t, inDir, map[string]string{"a.txt": strings.Repeat("a", 1000)})
+
+	o := Options{Compression: &CompressionOptions{Encoders: []Encoder{GzipEncoder{Level: 9}, upperEncoder{}}}}
+
+	man, err := HashToDirManifest(os.DirFS(inDir), outDir, o)
+	require.NoError(t, err)
+
+	e := man["a.txt"]
+	require.Len(t, e.Variants, 1)
+	assert.Equal(t, "gzip", e.Variants[0].Encoding)
+
+	// the upper-cased variant is not smaller than the file itself
+	assert.NoFileExists(t, filepath.Join(outDir, e.HashedPath+".up"))
+}
+
+func TestHashToDir_InvalidEncoder(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		compression CompressionOptions
+	}{
+		{name: "invalid level", compression: CompressionOptions{Level: 42}},
+		{name: "invalid encoder", compression: CompressionOptions{Encoders: []Encoder{GzipEncoder{Level: 42}}}},
+		{name: "empty suffix", compression: CompressionOptions{Encoders: []Encoder{emptySuffixEncoder{}}}},
+		{
+			name:        "duplicate suffix",
+			compression: CompressionOptions{Encoders: []Encoder{GzipEncoder{}, GzipEncoder{Level: 9}}},
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			o := Options{Compression: &c.compression}
+			_, err := HashToDir(os.DirFS(t.TempDir()), t.TempDir(), o)
+			assert.Error(t, err)
+		})
+	}
+}
+
+type emptySuffixEncoder struct{ upperEncoder }
+
+func (emptySuffixEncoder) Suffix() string { return "" }
@@ -0,0 +1,89 @@
+package hashets
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// forEach calls fn for each i in [0, n), using up to concurrency goroutines.
+//
+// It returns the first error returned by fn, or ctx.Err(), if ctx is
+// cancelled, after which fn is not called anymore.
+func forEach(ctx context.Context, n, concurrency int, fn func(i int) error) error {
+	if concurrency <= 1 || n <= 1 {
+		for i := 0; i < n; i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if err := fn(i); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if concurrency > n {
+		concurrency = n
+	}
+
+	var (
+		mu   sync.Mutex
+		next int
+		err  error
+		wg   sync.WaitGroup
+	)
+
+	wg.Add(concurrency)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			defer wg.Done()
+
+			for {
+				mu.Lock()
+				if err != nil || next >= n {
+					mu.Unlock()
+					return
+				}
+
+				i := next
+				next++
+				mu.Unlock()
+
+				ferr := ctx.Err()
+				if ferr == nil {
+					ferr = fn(i)
+				}
+
+				if ferr != nil {
+					mu.Lock()
+					if err == nil {
+						err = ferr
+					}
+					mu.Unlock()
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	return err
+}
+
+// contextReader is an [io.Reader] that fails with ctx.Err(), once ctx is
+// cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.r.Read(p)
+}
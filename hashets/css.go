@@ -0,0 +1,169 @@
+package hashets
+
+import (
+	"bytes"
+	"path"
+	"strings"
+)
+
+// CSSRewriter is a [Rewriter] for CSS files.
+//
+// It rewrites the URLs of url() functions and @import rules.
+type CSSRewriter struct {
+	// Prefix is the URL path under which the root of the hashed [fs.FS] is
+	// served, e.g. "/static/".
+	// It is used to resolve and rewrite absolute URLs.
+	//
+	// If Prefix is empty, absolute URLs are resolved relative to the root of
+	// the [fs.FS].
+	Prefix string
+}
+
+var _ Rewriter = CSSRewriter{}
+
+// Match reports whether p has the extension ".css".
+func (rw CSSRewriter) Match(p string) bool {
+	return strings.EqualFold(path.Ext(p), ".css")
+}
+
+func (rw CSSRewriter) References(p string, data []byte) []string {
+	return references(p, rw.Prefix, data, scanCSS(data))
+}
+
+func (rw CSSRewriter) Rewrite(p string, data []byte, m Map) []byte {
+	return rewrite(p, rw.Prefix, data, scanCSS(data), m)
+}
+
+// scanCSS returns the spans of all URLs in the passed CSS.
+func scanCSS(data []byte) []span {
+	var spans []span
+
+	for i := 0; i < len(data); {
+		switch {
+		case bytes.HasPrefix(data[i:], []byte("/*")):
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return spans
+			}
+
+			i += 2 + end + 2
+		case data[i] == '"' || data[i] == '\'':
+			i = skipString(data, i)
+		case hasPrefixFold(data[i:], "url(") && (i == 0 || !isCSSIdentByte(data[i-1])):
+			s, end, ok := scanCSSURL(data, i+len("url("))
+			if ok {
+				spans = append(spans, s)
+			}
+
+			i = end
+		case hasPrefixFold(data[i:], "@import"):
+			i = skipSpace(data, i+len("@import"))
+			if i < len(data) && (data[i] == '"' || data[i] == '\'') {
+				s, end, ok := scanString(data, i)
+				if ok {
+					spans = append(spans, s)
+				}
+
+				i = end
+			}
+		default:
+			i++
+		}
+	}
+
+	return spans
+}
+
+// scanCSSURL scans the argument of the url() function starting at i.
+//
+// It returns the span of the URL and the position after the url() function.
+func scanCSSURL(data []byte, i int) (_ span, end int, ok bool) {
+	i = skipSpace(data, i)
+	if i >= len(data) {
+		return span{}, i, false
+	}
+
+	if data[i] == '"' || data[i] == '\'' {
+		s, end, ok := scanString(data, i)
+		if !ok {
+			return span{}, end, false
+		}
+
+		end = skipSpace(data, end)
+		if end < len(data) && data[end] == ')' {
+			end++
+		}
+
+		return s, end, true
+	}
+
+	start := i
+	for i < len(data) && data[i] != ')' {
+		i++
+	}
+
+	if i >= len(data) {
+		return span{}, i, false
+	}
+
+	s := span{start: start, end: start + len(bytes.TrimRight(data[start:i], " \t\r\n\f"))}
+	return s, i + 1, true
+}
+
+// scanString scans the string literal starting at i.
+//
+// It returns the span of the string's contents, excluding the quotes, and the
+// position after the string literal.
+func scanString(data []byte, i int) (_ span, end int, ok bool) {
+	end = skipString(data, i)
+	if end-1 <= i || data[end-1] != data[i] {
+		return span{}, end, false
+	}
+
+	return span{start: i + 1, end: end - 1}, end, true
+}
+
+// skipString returns the position after the string literal starting at i.
+//
+// If the string is unterminated, the position of the line break or the end of
+// data is returned.
+func skipString(data []byte, i int) int {
+	quote := data[i]
+
+	for i++; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		case '\n':
+			// unterminated string
+			return i
+		}
+	}
+
+	return len(data)
+}
+
+// skipSpace returns the position of the first non-whitespace byte in data,
+// starting at i.
+func skipSpace(data []byte, i int) int {
+	for i < len(data) && isSpace(data[i]) {
+		i++
+	}
+
+	return i
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
+
+func isCSSIdentByte(b byte) bool {
+	return b == '-' || b == '_' || b >= 0x80 ||
+		'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9'
+}
+
+func hasPrefixFold(data []byte, prefix string) bool {
+	return len(data) >= len(prefix) && strings.EqualFold(string(data[:len(prefix)]), prefix)
+}
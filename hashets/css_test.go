@@ -0,0 +1,80 @@
+package hashets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSSRewriter(t *testing.T) {
+	t.Parallel()
+
+	m := Map{
+		"fonts/x.woff2":  "fonts/x_1234.woff2",
+		"img/a b.png":    "img/a b_1234.png",
+		"css/base.css":   "css/base_1234.css",
+		"css/nested.css": "nested/css_1234.css",
+	}
+
+	cases := []struct {
+		name             string
+		prefix           string
+		in               string
+		expect           string
+		expectReferences []string
+	}{
+		{
+			name:             "relative",
+			in:               `@font-face { src: url(../fonts/x.woff2) format("woff2"); }`,
+			expect:           `@font-face { src: url(../fonts/x_1234.woff2) format("woff2"); }`,
+			expectReferences: []string{"fonts/x.woff2"},
+		},
+		{
+			name:             "quoted with query",
+			in:               `a { background: URL( '../img/a%20b.png?v=1#x' ) }`,
+			expect:           `a { background: URL( '../img/a%20b_1234.png?v=1#x' ) }`,
+			expectReferences: []string{"img/a b.png"},
+		},
+		{
+			name:             "import",
+			in:               `@import "base.css"; @import url("./base.css") screen;`,
+			expect:           `@import "base_1234.css"; @import url("./base_1234.css") screen;`,
+			expectReferences: []string{"css/base.css"},
+		},
+		{
+			name:             "absolute",
+			prefix:           "/static",
+			in:               `a { background: url("/static/img/a b.png") } b { background: url(/other/x.png) }`,
+			expect:           `a { background: url("/static/img/a b_1234.png") } b { background: url(/other/x.png) }`,
+			expectReferences: []string{"img/a b.png"},
+		},
+		{
+			name:             "moved",
+			in:               `@import "nested.css";`,
+			expect:           `@import "../nested/css_1234.css";`,
+			expectReferences: []string{"css/nested.css"},
+		},
+		{
+			name: "ignored",
+			in: `/* url(../fonts/x.woff2) */ a::before { content: "url(../fonts/x.woff2)" }
+b { background: url(data:image/png;base64,AAAA), url(https://example.com/x.png), url(#svg) }
+c { background: my-url(../fonts/x.woff2), url(unknown.png) }`,
+			expect: `/* url(../fonts/x.woff2) */ a::before { content: "url(../fonts/x.woff2)" }
+b { background: url(data:image/png;base64,AAAA), url(https://example.com/x.png), url(#svg) }
+c { background: my-url(../fonts/x.woff2), url(unknown.png) }`,
+			expectReferences: []string{"css/unknown.png"},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			rw := CSSRewriter{Prefix: c.prefix}
+
+			assert.Equal(t, c.expectReferences, rw.References("css/site.css", []byte(c.in)))
+			assert.Equal(t, c.expect, string(rw.Rewrite("css/site.css", []byte(c.in), m)))
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package hashets
+
+import (
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+// Encoder is a compression algorithm used to precompress hashed files, see
+// [CompressionOptions.Encoders], and to serve them, see
+// [HandlerOptions.Encoders].
+//
+// hashets only ships with [GzipEncoder], so that users are not forced to
+// depend on other codecs.
+// Other codecs, such as brotli or zstd, can be plugged in by implementing
+// Encoder, and, optionally, registering the implementation using
+// [RegisterEncoder].
+type Encoder interface {
+	// Name returns the name of the encoder, e.g. "brotli".
+	// It is used to look up registered encoders, see [LookupEncoder].
+	Name() string
+	// Suffix returns the suffix appended to the names of the files encoded
+	// using the encoder, e.g. ".br".
+	Suffix() string
+	// ContentEncoding returns the value of the Content-Encoding header used
+	// for files encoded using the encoder, e.g. "br".
+	ContentEncoding() string
+	// NewWriter returns a new writer that writes the encoded contents of the
+	// data written to it to w.
+	//
+	// Closing the returned writer must flush all data to w, but must not
+	// close w.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
+
+// GzipEncoder is an [Encoder] that compresses files using gzip.
+//
+// It is registered under the name "gzip".
+type GzipEncoder struct {
+	// Level is the compression level, as accepted by [gzip.NewWriterLevel].
+	//
+	// Defaults to [gzip.DefaultCompression].
+	Level int
+}
+
+var _ Encoder = GzipEncoder{}
+
+func (GzipEncoder) Name() string            { return "gzip" }
+func (GzipEncoder) Suffix() string          { return ".gz" }
+func (GzipEncoder) ContentEncoding() string { return "gzip" }
+
+func (e GzipEncoder) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	level := e.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	return gzip.NewWriterLevel(w, level)
+}
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = []Encoder{GzipEncoder{}}
+)
+
+// RegisterEncoder registers the passed [Encoder], so that it can be looked up
+// by its name using [LookupEncoder].
+//
+// Registered encoders are used by default by [Handler], and their suffixes
+// are used to recognize precompressed variants, e.g. by [RemoveStale].
+// The hashets command accepts the names of all registered encoders.
+//
+// RegisterEncoder is intended to be called from init functions.
+// It panics, if e's name or suffix is empty, or if an encoder with the same
+// name is already registered.
+func RegisterEncoder(e Encoder) {
+	if e.Name() == "" || e.Suffix() == "" {
+		panic("hashets: RegisterEncoder: encoder name and suffix must not be empty")
+	}
+
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+
+	for _, registered := range encoders {
+		if registered.Name() == e.Name() {
+			panic("hashets: RegisterEncoder: encoder " + e.Name() + " registered twice")
+		}
+	}
+
+	encoders = append(encoders, e)
+}
+
+// LookupEncoder returns the registered [Encoder] with the passed name.
+func LookupEncoder(name string) (Encoder, bool) {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+
+	for _, e := range encoders {
+		if e.Name() == name {
+			return e, true
+		}
+	}
+
+	return nil, false
+}
+
+// Encoders returns the registered encoders, in the order they were
+// registered.
+func Encoders() []Encoder {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+
+	return append([]Encoder(nil), encoders...)
+}
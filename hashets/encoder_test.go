@@ -0,0 +1,72 @@
+package hashets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// upperEncoder is an [Encoder] that "encodes" files by upper-casing them.
+type upperEncoder struct{}
+
+func (upperEncoder) Name() string            { return "upper" }
+func (upperEncoder) Suffix() string          { return ".up" }
+func (upperEncoder) ContentEncoding() string { return "x-upper" }
+
+func (upperEncoder) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return &upperWriter{w: w}, nil
+}
+
+type upperWriter struct {
+	w io.Writer
+}
+
+func (w *upperWriter) Write(p []byte) (int, error) { return w.w.Write(bytes.ToUpper(p)) }
+func (w *upperWriter) Close() error                { return nil }
+
+func TestLookupEncoder(t *testing.T) {
+	t.Parallel()
+
+	enc, ok := LookupEncoder("gzip")
+	require.True(t, ok)
+	assert.Equal(t, GzipEncoder{}, enc)
+
+	_, ok = LookupEncoder("upper")
+	assert.False(t, ok)
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	t.Parallel()
+
+	assert.Panics(t, func() { RegisterEncoder(GzipEncoder{Level: 9}) })
+	assert.Panics(t, func() { RegisterEncoder(emptyEncoder{}) })
+	assert.Len(t, Encoders(), 1)
+}
+
+type emptyEncoder struct{ upperEncoder }
+
+func (emptyEncoder) Name() string { return "" }
+
+func TestGzipEncoder(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	w, err := GzipEncoder{}.NewWriter(&buf)
+	require.NoError(t, err)
+	_, err = io.WriteString(w, "hello")
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	zr, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	_, err = GzipEncoder{Level: 42}.NewWriter(io.Discard)
+	assert.Error(t, err)
+}
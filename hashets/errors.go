@@ -0,0 +1,119 @@
+package hashets
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FileError is the error collected for a file that could not be hashed, if
+// [Options.CollectErrors] is set.
+type FileError struct {
+	// Path is the path of the file, or, if the error occurred while reading a
+	// directory, the path of that directory.
+	Path string
+	Err  error
+}
+
+func (err *FileError) Error() string {
+	return err.Path + ": " + err.Err.Error()
+}
+
+func (err *FileError) Unwrap() error {
+	return err.Err
+}
+
+// FileErrors is the error returned if [Options.CollectErrors] is set and
+// one or more files could not be hashed.
+//
+// The errors are sorted by path.
+type FileErrors []*FileError
+
+func (errs FileErrors) Error() string {
+	var b strings.Builder
+
+	b.WriteString("failed to hash ")
+	if len(errs) == 1 {
+		b.WriteString("1 file:")
+	} else {
+		b.WriteString(strconv.Itoa(len(errs)) + " files:")
+	}
+
+	for _, err := range errs {
+		b.WriteString("\n")
+		b.WriteString(err.Error())
+	}
+
+	return b.String()
+}
+
+// Unwrap returns the [*FileError]s, so that they can be inspected using
+// [errors.Is] and [errors.As].
+func (errs FileErrors) Unwrap() []error {
+	unwrapped := make([]error, len(errs))
+	for i, err := range errs {
+		unwrapped[i] = err
+	}
+
+	return unwrapped
+}
+
+// errorCollector collects the per-file errors of a hashing run.
+type errorCollector struct {
+	collect bool
+
+	mu   sync.Mutex
+	errs FileErrors
+}
+
+// handle returns err, unless errors are collected, in which case err is
+// added to the collected errors, and handle returns nil.
+//
+// Errors caused by the cancellation of ctx are never collected.
+func (c *errorCollector) handle(ctx context.Context, p string, err error) error {
+	if err == nil || !c.collect || ctx.Err() != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.errs = append(c.errs, &FileError{Path: p, Err: err})
+	c.mu.Unlock()
+	return nil
+}
+
+// err returns the collected errors sorted by path, or nil, if there are
+// none.
+func (c *errorCollector) err() error {
+	if len(c.errs) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(c.errs, func(i, j int) bool { return c.errs[i].Path < c.errs[j].Path })
+	return c.errs
+}
+
+// CollisionError is the error returned if the hashed path of a file is
+// already the hashed path of another file, or if it is the path of another
+// file of the hashed [fs.FS], which would be overwritten or shadowed.
+type CollisionError struct {
+	// Path is the path of the file whose hashed path collides.
+	Path string
+	// HashedPath is the hashed path of the file.
+	HashedPath string
+	// Other is the path of the other file.
+	//
+	// If Other equals HashedPath, HashedPath is the path of a file of the
+	// hashed fs.FS.
+	// Otherwise, HashedPath is also the hashed path of Other.
+	Other string
+}
+
+func (err *CollisionError) Error() string {
+	if err.Other == err.HashedPath {
+		return "hashed path of " + err.Path + " collides with existing file " + err.Other
+	}
+
+	return err.Path + " and " + err.Other + " have the same hashed path " + err.HashedPath
+}
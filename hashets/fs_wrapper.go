@@ -9,7 +9,13 @@ import (
 // the contents of "foo.txt".
 type FSWrapper struct {
 	filesys    fs.FS
-	reverseMap map[string]string // hashed name -> original name
+	m          Map
+	reverseMap map[string]string // hashed name -> original name, or itself
+	// history maps the hashed names of previous generations to the original
+	// names.
+	history map[string]string
+	// naming is the Options.Naming used to hash the files, if known.
+	naming NamingScheme
 }
 
 var (
@@ -26,21 +32,98 @@ var (
 //
 // Files that are ignored, are left unhashed and can be accessed by their
 // original file names.
+// If [Options.PassThrough] is set, they are mapped to themselves.
 func WrapFS(filesys fs.FS, o Options) (*FSWrapper, Map, error) {
+	o.setDefaults()
+
 	m, err := Hash(filesys, o)
-	if err != nil {
+	if m == nil {
 		return nil, nil, err
 	}
 
+	fsw := NewFSWrapper(filesys, m)
+	fsw.naming = o.Naming
+
+	// if Options.CollectErrors is set, m and err may both be non-nil
+	return fsw, m, err
+}
+
+// NewFSWrapper returns a new [FSWrapper] that, for each hashed file name in m,
+// returns the original file of filesys.
+//
+// Unlike [WrapFS], it doesn't hash the files of filesys, so that it can be
+// used with a Map generated ahead of time, e.g. by the hashets command.
+func NewFSWrapper(filesys fs.FS, m Map) *FSWrapper {
 	reverseMap := make(map[string]string, len(m))
 	for k, v := range m {
+		// files mapped to themselves, see Options.PassThrough, are not hashed
+		if k == v {
+			continue
+		}
+
+		// if the original was already replaced by its hashed file, e.g.
+		// using the -replace flag of the hashets command, the hashed file is
+		// opened directly
+		if _, err := fs.Stat(filesys, k); err != nil {
+			reverseMap[v] = v
+			continue
+		}
+
 		reverseMap[v] = k
 	}
 
 	return &FSWrapper{
 		filesys:    filesys,
+		m:          m,
 		reverseMap: reverseMap,
-	}, m, nil
+	}
+}
+
+// WithHistory returns a copy of fsw, that additionally maps the hashed names
+// of the generations in h to the original file names.
+//
+// When a hashed name of a previous generation is opened, the file with that
+// name is returned, if it exists in the wrapped [fs.FS], e.g. because it was
+// kept by [RemoveStale].
+// Otherwise, the current version of the original file is returned.
+//
+// If multiple generations map the same hashed name to different original
+// names, the more recent generation takes precedence.
+func (fsw *FSWrapper) WithHistory(h History) *FSWrapper {
+	cp := *fsw
+	cp.history = make(map[string]string, len(fsw.history))
+	for hashed, orig := range fsw.history {
+		cp.history[hashed] = orig
+	}
+
+	for i := len(h) - 1; i >= 0; i-- {
+		for orig, e := range h[i] {
+			if e.HashedPath == orig {
+				continue
+			}
+
+			if _, ok := cp.reverseMap[e.HashedPath]; !ok {
+				cp.history[e.HashedPath] = orig
+			}
+		}
+	}
+
+	return &cp
+}
+
+// IsPrevious reports whether name is the hashed name of a file in one of the
+// previous generations added using [FSWrapper.WithHistory], but not its
+// current hashed name.
+func (fsw *FSWrapper) IsPrevious(name string) bool {
+	_, ok := fsw.history[name]
+	return ok
+}
+
+// IsHashed reports whether name is the hashed name of a file of the wrapped
+// [fs.FS].
+func (fsw *FSWrapper) IsHashed(name string) bool {
+	_, ok := fsw.reverseMap[name]
+	return ok
 }
 
 // Open returns the file represented by the passed hashed name.
@@ -48,17 +131,25 @@ func WrapFS(filesys fs.FS, o Options) (*FSWrapper, Map, error) {
 // If there is no file mapped to the passed name, it looks for directly for a
 // file with the given name.
 func (fsw *FSWrapper) Open(name string) (fs.File, error) {
-	if origName, ok := fsw.reverseMap[name]; ok {
-		name = origName
-	}
-
-	return fsw.filesys.Open(name)
+	return fsw.filesys.Open(fsw.resolve(name))
 }
 
 func (fsw *FSWrapper) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(fsw.filesys, fsw.resolve(name))
+}
+
+// resolve returns the name of the file of the wrapped [fs.FS] that is
+// opened for name.
+func (fsw *FSWrapper) resolve(name string) string {
 	if origName, ok := fsw.reverseMap[name]; ok {
-		name = origName
+		return origName
+	}
+
+	if origName, ok := fsw.history[name]; ok {
+		if _, err := fs.Stat(fsw.filesys, name); err != nil {
+			return origName
+		}
 	}
 
-	return fs.ReadFile(fsw.filesys, name)
+	return name
 }
@@ -2,7 +2,11 @@ package hashets
 
 import (
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -34,3 +38,27 @@ func TestWrapFS(t *testing.T) {
 		assert.Equal(t, expect, actual)
 	}
 }
+
+func TestWrapFS_Replaced(t *testing.T) {
+	t.Parallel()
+
+	hashed, err := HashFile("a.txt", strings.NewReader("a"), Options{})
+	require.NoError(t, err)
+
+	// the state after running hashets -replace
+	inFS := fstest.MapFS{hashed: {Data: []byte("a")}}
+
+	fsw, m, err := WrapFS(inFS, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, Map{"a.txt": hashed}, m)
+
+	data, err := fsw.ReadFile(hashed)
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(data))
+
+	rec := httptest.NewRecorder()
+	NewHandler(fsw, HandlerOptions{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+hashed, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, ImmutableCacheControl, rec.Header().Get("Cache-Control"))
+	assert.Equal(t, "a", rec.Body.String())
+}
@@ -0,0 +1,173 @@
+package hashets
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RemoveStale removes the hashed files in the directory at dirPath that are
+// not in m, e.g. because they were written by a previous call to [HashToDir]
+// for an older version of a file.
+//
+// A file is considered a hashed file, if its path can be parsed by
+// [Options.Naming].
+// Directories that the naming scheme placed stale hashed files in, e.g. when
+// using [DirNaming], are removed as well, if they are empty afterwards.
+// Files that are ignored by [Options.Ignore], and files that are keys of m,
+// are never removed.
+// The precompressed variants of hashed files, i.e. files named after them
+// with the suffix of a registered [Encoder] or of one of the encoders in
+// [Options.Compression], are removed together with the hashed files.
+//
+// If keep is greater than 0, the keep most recently modified previous
+// versions of each file are kept, so that clients still using an older
+// version of a page can continue to load its assets, e.g. during a
+// zero-downtime deploy.
+// If the naming scheme cannot recover the original paths from the hashed
+// paths, as is the case for [ContentAddressedNaming], the keep most recently
+// modified stale files are kept, regardless of their originals.
+// Additionally, the hashed files of the generations stored in the history of
+// the directory are never removed, see [AppendHistory].
+//
+// RemoveStale returns the paths of the removed files, relative to dirPath.
+// If it returns an error, the files already removed up to that point are
+// returned as well.
+func RemoveStale(dirPath string, m Map, keep int, o Options) (removed []string, _ error) {
+	o.setDefaults()
+	hashLen := o.hashLen()
+	suffixes := o.variantSuffixes()
+
+	h, err := ReadHistory(os.DirFS(dirPath), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]struct{}, 2*len(m))
+	for _, m := range append([]Map{m}, h.maps()...) {
+		for orig, hashed := range m {
+			current[orig] = struct{}{}
+			current[filePath(hashed)] = struct{}{}
+		}
+	}
+
+	type staleFile struct {
+		path    string
+		modTime time.Time
+	}
+
+	// the stale hashed files, by the path of their original
+	stale := make(map[string][]staleFile)
+
+	err = fs.WalkDir(os.DirFS(dirPath), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if p == metaDir {
+				return fs.SkipDir
+			}
+
+			return nil
+		}
+
+		if o.Ignore(p) {
+			return nil
+		}
+
+		if _, ok := current[p]; ok || isVariant(p, current, suffixes) {
+			return nil
+		}
+
+		// variants are removed together with their hashed file
+		for _, suffix := range suffixes {
+			if trimmed, ok := strings.CutSuffix(p, suffix); ok {
+				if _, _, ok := o.parseHashedPath(trimmed, hashLen); ok {
+					return nil
+				}
+			}
+		}
+
+		orig, _, ok := o.parseHashedPath(p, hashLen)
+		if !ok {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		stale[orig] = append(stale[orig], staleFile{path: p, modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the directories that only exist because the naming scheme placed the
+	// hashed files in them
+	var hashDirs []string
+
+	for orig, files := range stale {
+		if len(files) <= keep {
+			continue
+		}
+
+		// newest first
+		sort.Slice(files, func(i, j int) bool {
+			if files[i].modTime.Equal(files[j].modTime) {
+				return files[i].path > files[j].path
+			}
+
+			return files[i].modTime.After(files[j].modTime)
+		})
+
+		if keep > 0 {
+			files = files[keep:]
+		}
+
+		for _, f := range files {
+			removed = append(removed, f.path)
+
+			if dir := path.Dir(f.path); dir != path.Dir(orig) {
+				hashDirs = append(hashDirs, dir)
+			}
+		}
+	}
+
+	sort.Strings(removed)
+
+	var removedVariants []string
+	for i, p := range removed {
+		if err := os.Remove(filepath.Join(dirPath, filepath.FromSlash(p))); err != nil {
+			return append(removed[:i], removedVariants...), err
+		}
+
+		for _, suffix := range suffixes {
+			err := os.Remove(filepath.Join(dirPath, filepath.FromSlash(p+suffix)))
+			if err == nil {
+				removedVariants = append(removedVariants, p+suffix)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return append(removed[:i+1], removedVariants...), err
+			}
+		}
+	}
+
+	removed = append(removed, removedVariants...)
+	sort.Strings(removed)
+
+	// remove the directories that are empty now, deepest first
+	sort.Sort(sort.Reverse(sort.StringSlice(hashDirs)))
+	for _, dir := range hashDirs {
+		_ = os.Remove(filepath.Join(dirPath, filepath.FromSlash(dir)))
+	}
+
+	return removed, nil
+}
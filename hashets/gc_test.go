@@ -0,0 +1,135 @@
+package hashets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveStale(t *testing.T) {
+	t.Parallel()
+
+	const (
+		h1 = "1111111111111111111111111111111111111111111111111111111111111111"
+		h2 = "2222222222222222222222222222222222222222222222222222222222222222"
+		h3 = "3333333333333333333333333333333333333333333333333333333333333333"
+		h4 = "4444444444444444444444444444444444444444444444444444444444444444"
+	)
+
+	// file names by age, oldest first
+	files := []string{
+		"a_" + h1 + ".css",
+		"a_" + h2 + ".css",
+		"a_" + h3 + ".css",
+		"a_" + h4 + ".css",
+		"js/deleted_" + h1 + ".js",
+		"js/deleted_" + h2 + ".js",
+		"a.css",
+		"unrelated.txt",
+		"ignored_" + h1 + ".txt",
+	}
+
+	m := Map{"a.css": "a_" + h4 + ".css"}
+	o := Options{Ignore: IgnorePrefix("ignored")}
+
+	testCases := []struct {
+		name          string
+		keep          int
+		expectRemoved []string
+	}{
+		{
+			name: "keep 0",
+			keep: 0,
+			expectRemoved: []string{
+				"a_" + h1 + ".css",
+				"a_" + h2 + ".css",
+				"a_" + h3 + ".css",
+				"js/deleted_" + h1 + ".js",
+				"js/deleted_" + h2 + ".js",
+			},
+		},
+		{
+			name:          "keep 1",
+			keep:          1,
+			expectRemoved: []string{"a_" + h1 + ".css", "a_" + h2 + ".css", "js/deleted_" + h1 + ".js"},
+		},
+		{
+			name:          "keep 3",
+			keep:          3,
+			expectRemoved: nil,
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			modTime := time.Now().Add(-time.Hour)
+			for _, f := range files {
+				p := filepath.Join(dir, filepath.FromSlash(f))
+				require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+				require.NoError(t, os.WriteFile(p, nil, 0o644))
+				require.NoError(t, os.Chtimes(p, modTime, modTime))
+				modTime = modTime.Add(time.Minute)
+			}
+
+			removed, err := RemoveStale(dir, m, c.keep, o)
+			require.NoError(t, err)
+			assert.Equal(t, c.expectRemoved, removed)
+
+			for _, f := range files {
+				_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f)))
+				assert.Equal(t, !contains(c.expectRemoved, f), err == nil, f)
+			}
+		})
+	}
+}
+
+func contains(s []string, v string) bool {
+	for _, sv := range s {
+		if sv == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestRemoveStale_History(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, f := range []string{"a_1111.js", "a_2222.js", "a_3333.js"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, f), nil, 0o644))
+	}
+
+	require.NoError(t, AppendHistory(dir, Manifest{"a.js": {Path: "a.js", HashedPath: "a_2222.js"}}, 0))
+
+	o := Options{HashToText: func([]byte) string { return "0000" }}
+
+	removed, err := RemoveStale(dir, Map{"a.js": "a_3333.js"}, 0, o)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a_1111.js"}, removed)
+}
+
+func TestRemoveStale_Variants(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, f := range []string{"a_1111.js", "a_1111.js.gz", "a_2222.js", "a_2222.js.gz"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, f), nil, 0o644))
+	}
+
+	o := Options{HashToText: func([]byte) string { return "0000" }}
+
+	removed, err := RemoveStale(dir, Map{"a.js": "a_2222.js"}, 0, o)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a_1111.js", "a_1111.js.gz"}, removed)
+	assert.FileExists(t, filepath.Join(dir, "a_2222.js.gz"))
+}
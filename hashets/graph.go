@@ -0,0 +1,572 @@
+package hashets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// A ReferenceExtractor extracts the references a file makes to other files.
+//
+// If a file references other files, the hashes of the referenced files are
+// folded into the hash of the referencing file, so that its hash changes,
+// whenever the hash of a file it references changes.
+type ReferenceExtractor interface {
+	// Match reports whether the ReferenceExtractor handles the file with the
+	// given path.
+	Match(path string) bool
+
+	// References returns the paths of the files referenced by the file with
+	// the given path and contents.
+	//
+	// The returned paths are relative to the root of the hashed [fs.FS].
+	// References that cannot be resolved to such a path, such as external
+	// URLs, are not returned.
+	References(path string, data []byte) []string
+}
+
+// CycleError is the error returned if files reference each other in a cycle.
+type CycleError struct {
+	// Paths are the paths of the files forming the cycle.
+	// The first path is repeated as the last path.
+	Paths []string
+}
+
+func (err *CycleError) Error() string {
+	return "reference cycle: " + strings.Join(err.Paths, " -> ")
+}
+
+// writeFunc writes the hashed equivalent of the file described by e,
+// reading the contents from r, and returns its precompressed variants.
+//
+// If keepExisting is true, and the hashed equivalent already exists, it is
+// left as is.
+type writeFunc func(e Entry, r io.Reader, keepExisting bool) ([]Variant, error)
+
+// file is a file to be hashed.
+type file struct {
+	path string
+	// src is the path of the file to read the contents from.
+	//
+	// Usually, src is path.
+	// However, if path was already replaced by its hashed equivalent, src is
+	// the path of the hashed equivalent, and the file is left as is.
+	src string
+
+	// data are the contents of the file, if loaded is true.
+	//
+	// Only files handled by a ReferenceExtractor are loaded in advance.
+	data   []byte
+	loaded bool
+	rw     Rewriter
+	deps   []*file // sorted by path
+
+	entry Entry
+	// failed is true, if f could not be hashed, and the error was collected.
+	failed bool
+	// shared is the file that f shares its hashed file with, because both
+	// have the same contents, e.g. when using [ContentAddressedNaming].
+	// f itself is not written.
+	shared *file
+}
+
+// hashFiles hashes the files at the passed paths, in an order that ensures
+// that files are hashed after the files they reference.
+//
+// Files that are the hashed equivalents of other files, are handled as
+// described in [recognizeHashed].
+//
+// If write is not nil, the hashed files are written using write, after being
+// rewritten by the matching [Options.Rewriters].
+//
+// If sri is not empty, the SRI strings of the files, as written, are computed
+// as well.
+//
+// hashFiles stops hashing as soon as ctx is cancelled, and returns ctx.Err().
+//
+// If errs collects errors, the files that could not be hashed, and the files
+// referencing them, are left out of the returned Manifest, and their errors
+// are added to errs.
+func hashFiles(
+	ctx context.Context, inFS fs.FS, paths, ignored []string, o Options, write writeFunc, sri SRIAlgorithm,
+	errs *errorCollector,
+) (Manifest, error) {
+	paths, prehashed, skipped, err := recognizeHashed(ctx, inFS, paths, o)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*file, len(paths)+len(prehashed))
+	for _, p := range paths {
+		files[p] = &file{path: p, src: p}
+	}
+
+	for _, f := range prehashed {
+		files[f.path] = f
+		paths = append(paths, f.path)
+	}
+
+	err = forEach(ctx, len(paths), o.Concurrency, func(i int) error {
+		f := files[paths[i]]
+		if err := extractReferences(inFS, f, files, o, write != nil); err != nil {
+			f.failed = true
+			return errs.handle(ctx, f.src, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := sortFiles(files, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(Map, len(paths))
+	man := make(Manifest, len(paths))
+	// claimed maps the hashed paths to the paths of the files they belong to
+	claimed := make(map[string]string, len(paths))
+
+	for _, level := range levels(order) {
+		// m is only read while hashing the files of a level, so that the files
+		// of a level can be hashed concurrently
+		err := forEach(ctx, len(level), o.Concurrency, func(i int) error {
+			f := level[i]
+			if f.failed {
+				return nil
+			}
+
+			for _, dep := range f.deps {
+				if dep.failed {
+					f.failed = true
+					return errs.handle(ctx, f.src, fmt.Errorf("references %s, which could not be hashed", dep.path))
+				}
+			}
+
+			if err := hashFile(ctx, inFS, f, m, o, sri); err != nil {
+				f.failed = true
+				return errs.handle(ctx, f.src, err)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// check for collisions in order, so that the same file always wins
+		for _, f := range level {
+			if f.failed {
+				continue
+			}
+
+			if other, ok := claimed[f.entry.HashedPath]; ok && bytes.Equal(files[other].entry.Digest, f.entry.Digest) {
+				f.shared = files[other]
+				continue
+			}
+
+			if err := checkCollision(inFS, f, files, claimed, skipped); err != nil {
+				f.failed = true
+				if err := errs.handle(ctx, f.src, err); err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+
+			claimed[f.entry.HashedPath] = f.path
+		}
+
+		if write != nil {
+			err := forEach(ctx, len(level), o.Concurrency, func(i int) error {
+				f := level[i]
+				if f.failed || f.shared != nil {
+					return nil
+				}
+
+				if err := writeHashed(ctx, inFS, f, write); err != nil {
+					f.failed = true
+					return errs.handle(ctx, f.src, err)
+				}
+
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, f := range level {
+			if f.failed {
+				continue
+			}
+
+			if f.shared != nil {
+				if f.shared.failed {
+					f.failed = true
+					err := fmt.Errorf("shares its hashed file with %s, which could not be hashed", f.shared.path)
+					if err := errs.handle(ctx, f.src, err); err != nil {
+						return nil, err
+					}
+
+					continue
+				}
+
+				f.entry.Variants = f.shared.entry.Variants
+			}
+
+			m[f.path] = f.entry.HashedPath
+			man[f.path] = f.entry
+		}
+	}
+
+	if o.PassThrough {
+		for _, p := range ignored {
+			e, err := passThroughEntry(inFS, p)
+			if err != nil {
+				if err := errs.handle(ctx, p, err); err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+
+			man[p] = e
+		}
+	}
+
+	return man, errs.err()
+}
+
+// passThroughEntry returns the [Entry] of the ignored file at p, that maps
+// the file to itself, see [Options.PassThrough].
+func passThroughEntry(inFS fs.FS, p string) (Entry, error) {
+	f, err := inFS.Open(p)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return Entry{}, err
+	}
+
+	return Entry{
+		Path:        p,
+		HashedPath:  p,
+		Size:        stat.Size(),
+		ContentType: contentType(p, head[:n]),
+		Mode:        stat.Mode(),
+		Unhashed:    true,
+	}, nil
+}
+
+// extractReferences loads the contents of f and resolves the files it
+// references, if f is handled by a [ReferenceExtractor].
+//
+// Files that were already replaced by their hashed equivalents are left as
+// is.
+func extractReferences(inFS fs.FS, f *file, files map[string]*file, o Options, rewrite bool) error {
+	if f.src != f.path {
+		return nil
+	}
+
+	var ext ReferenceExtractor
+	if rewrite {
+		if f.rw = o.rewriter(f.path); f.rw != nil {
+			ext = f.rw
+		}
+	}
+
+	if ext == nil {
+		if ext = o.extractor(f.path); ext == nil {
+			return nil
+		}
+	}
+
+	data, err := fs.ReadFile(inFS, f.path)
+	if err != nil {
+		return err
+	}
+
+	f.data, f.loaded = data, true
+
+	for _, ref := range ext.References(f.path, data) {
+		if dep, ok := files[ref]; ok && ref != f.path {
+			f.deps = append(f.deps, dep)
+		}
+	}
+
+	sort.Slice(f.deps, func(i, j int) bool { return f.deps[i].path < f.deps[j].path })
+	return nil
+}
+
+// sortFiles sorts the passed files topologically, so that each file comes
+// after the files it references.
+//
+// If the files reference each other in a cycle, a [*CycleError] is returned.
+func sortFiles(files map[string]*file, paths []string) ([]*file, error) {
+	const (
+		visiting = iota + 1
+		done
+	)
+
+	order := make([]*file, 0, len(paths))
+	state := make(map[*file]int, len(paths))
+
+	var stack []*file
+
+	var visit func(f *file) error
+	visit = func(f *file) error {
+		switch state[f] {
+		case visiting:
+			var cycle []string
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i] == f {
+					for _, f := range stack[i:] {
+						cycle = append(cycle, f.path)
+					}
+
+					break
+				}
+			}
+
+			return &CycleError{Paths: append(cycle, f.path)}
+		case done:
+			return nil
+		}
+
+		state[f] = visiting
+		stack = append(stack, f)
+
+		for _, dep := range f.deps {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[f] = done
+		order = append(order, f)
+		return nil
+	}
+
+	for _, p := range paths {
+		if err := visit(files[p]); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// levels groups the passed topologically sorted files into levels, so that
+// each file only references files of previous levels.
+func levels(order []*file) [][]*file {
+	level := make(map[*file]int, len(order))
+
+	var levels [][]*file
+	for _, f := range order {
+		var l int
+		for _, dep := range f.deps {
+			if level[dep] >= l {
+				l = level[dep] + 1
+			}
+		}
+
+		level[f] = l
+		if l == len(levels) {
+			levels = append(levels, nil)
+		}
+
+		levels[l] = append(levels[l], f)
+	}
+
+	return levels
+}
+
+// hashFile hashes f and sets f.entry.
+// It expects all files f references to be hashed already, and to be in m.
+//
+// If f.rw is set, f is rewritten using it, before being hashed.
+//
+// If sri is not empty, f's SRI string is computed as well.
+func hashFile(ctx context.Context, inFS fs.FS, f *file, m Map, o Options, sri SRIAlgorithm) error {
+	stat, err := fs.Stat(inFS, f.src)
+	if err != nil {
+		return err
+	}
+
+	if f.rw != nil {
+		f.data = f.rw.Rewrite(f.path, f.data, rewriteMap(f.path, m, o))
+	}
+
+	h := o.NewHash()
+	h.Reset()
+
+	var head headWriter
+	ws := []io.Writer{h, &head}
+
+	var sriHash hash.Hash
+	if sri != "" {
+		sriHash = sri.new()
+		ws = append(ws, sriHash)
+	}
+
+	w := io.MultiWriter(ws...)
+	if f.loaded {
+		_, _ = w.Write(f.data)
+	} else if err := copyFile(ctx, w, inFS, f.src); err != nil {
+		return err
+	}
+
+	digest := h.Sum(nil)
+
+	// The contents of rewritten files already contain the hashes of the
+	// files they reference.
+	if f.rw == nil && len(f.deps) > 0 {
+		h.Reset()
+		_, _ = h.Write(digest)
+		for _, dep := range f.deps {
+			_, _ = h.Write(dep.entry.Digest)
+		}
+
+		digest = h.Sum(nil)
+	}
+
+	f.entry = Entry{
+		Path:        f.path,
+		Digest:      digest,
+		Hash:        o.HashToText(digest),
+		Size:        head.n,
+		ContentType: contentType(f.path, head.head),
+		Mode:        stat.Mode(),
+	}
+	if f.src != f.path {
+		f.entry.HashedPath = f.src
+	} else {
+		f.entry.HashedPath = o.Naming.Format(f.path, f.entry.Hash)
+	}
+	if sriHash != nil {
+		f.entry.Integrity = sri.encode(sriHash.Sum(nil))
+	}
+
+	return nil
+}
+
+// rewriteMap returns the [Map] passed to the Rewriter of the file at p.
+//
+// If the naming scheme places the hashed file in another directory than the
+// original file, it returns a copy of m that maps p to its hashed path with a
+// placeholder hash, so that relative references are rewritten relative to
+// the directory of the hashed file.
+func rewriteMap(p string, m Map, o Options) Map {
+	loc := o.Naming.Format(p, strings.Repeat("0", o.hashLen()))
+	if path.Dir(filePath(loc)) == path.Dir(p) {
+		return m
+	}
+
+	rm := make(Map, len(m)+1)
+	for k, v := range m {
+		rm[k] = v
+	}
+
+	rm[p] = loc
+	return rm
+}
+
+// checkCollision checks whether the hashed path of f is already claimed by
+// another file, or is the path of another file of inFS.
+//
+// Files in skipped are hashed files of previous runs, which may be
+// overwritten.
+func checkCollision(
+	inFS fs.FS, f *file, files map[string]*file, claimed map[string]string, skipped map[string]struct{},
+) error {
+	hashed := f.entry.HashedPath
+	if other, ok := claimed[hashed]; ok {
+		return &CollisionError{Path: f.path, HashedPath: hashed, Other: other}
+	}
+
+	// files hashed using QueryNaming keep their path
+	fp := filePath(hashed)
+	if fp == f.src || fp == f.path {
+		return nil
+	}
+
+	if _, ok := skipped[fp]; ok {
+		return nil
+	}
+
+	if _, ok := files[fp]; ok {
+		return &CollisionError{Path: f.path, HashedPath: hashed, Other: hashed}
+	}
+
+	// hashed may also be the path of an ignored file
+	if _, err := fs.Stat(inFS, fp); err == nil {
+		return &CollisionError{Path: f.path, HashedPath: hashed, Other: hashed}
+	}
+
+	return nil
+}
+
+// writeHashed writes the hashed file f using write.
+// It expects f to be hashed already.
+//
+// If f was already replaced by its hashed equivalent, it is only written, if
+// the hashed equivalent doesn't exist yet, i.e. if the output directory is
+// not the hashed directory.
+func writeHashed(ctx context.Context, inFS fs.FS, f *file, write writeFunc) error {
+	prehashed := f.src != f.path
+
+	if f.loaded {
+		var err error
+		f.entry.Variants, err = write(f.entry, bytes.NewReader(f.data), prehashed)
+		return err
+	}
+
+	in, err := inFS.Open(f.src)
+	if err != nil {
+		return err
+	}
+
+	f.entry.Variants, err = write(f.entry, &contextReader{ctx: ctx, r: in}, prehashed)
+	if err != nil {
+		_ = in.Close()
+		return err
+	}
+
+	return in.Close()
+}
+
+// copyFile copies the contents of the file at p to w, aborting if ctx is
+// cancelled.
+func copyFile(ctx context.Context, w io.Writer, inFS fs.FS, p string) error {
+	in, err := inFS.Open(p)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, &contextReader{ctx: ctx, r: in}); err != nil {
+		_ = in.Close()
+		return err
+	}
+
+	return in.Close()
+}
@@ -0,0 +1,497 @@
+package hashets
+
+import (
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Common values for the Cache-Control header, to be used with
+// [HandlerOptions].
+const (
+	// ImmutableCacheControl allows caches to store a response for a year and
+	// to never revalidate it.
+	// This is safe for hashed files, as their names change whenever their
+	// contents change.
+	ImmutableCacheControl = "public, max-age=31536000, immutable"
+	// NoCacheControl allows caches to store a response, but requires them to
+	// revalidate it before every use.
+	NoCacheControl = "no-cache"
+)
+
+// Action is the action a [Handler] takes for requests of file names that are
+// not the current hashed name of a file.
+type Action uint8
+
+const (
+	// ActionServe serves the current version of the requested file.
+	//
+	// Since the file may change under the same name, the Cache-Control
+	// header is set to [HandlerOptions.UnhashedCacheControl].
+	ActionServe Action = iota + 1
+	// ActionRedirect redirects to the current hashed name of the requested
+	// file, using [HandlerOptions.RedirectCode].
+	ActionRedirect
+	// ActionNotFound responds with 404 Not Found.
+	ActionNotFound
+)
+
+// HandlerOptions provides configuration options for a [Handler].
+type HandlerOptions struct {
+	// HashedCacheControl is the value of the Cache-Control header sent for
+	// requests of hashed file names.
+	//
+	// Defaults to [ImmutableCacheControl].
+	HashedCacheControl string
+
+	// UnhashedCacheControl is the value of the Cache-Control header sent for
+	// requests of file names that are not hashed, e.g. because the file was
+	// ignored or because the original file name was requested.
+	//
+	// Defaults to [NoCacheControl].
+	UnhashedCacheControl string
+
+	// Unhashed is the action taken for requests of the original name of a
+	// hashed file, e.g. "foo.txt" instead of "foo_1234.txt".
+	//
+	// Defaults to [ActionServe].
+	Unhashed Action
+
+	// Stale is the action taken for requests of a hashed file name that is
+	// not the current hashed name of its file, e.g. because the file's
+	// contents changed since the name was generated.
+	//
+	// Requested file names are parsed using Naming to find out the original
+	// file name.
+	// If there is a file with the requested name, that file is always
+	// served instead.
+	//
+	// Defaults to [ActionNotFound].
+	Stale Action
+
+	// StaleQuery is the action taken for requests of a file hashed using
+	// [QueryNaming], whose query does not match the file's current hash.
+	//
+	// Requests without the query parameter are handled using Unhashed.
+	//
+	// Defaults to [ActionServe].
+	StaleQuery Action
+
+	// RedirectCode is the status code used for [ActionRedirect].
+	//
+	// Defaults to [http.StatusFound].
+	RedirectCode int
+
+	// Naming is the [Options.Naming] used to hash the files.
+	//
+	// Defaults to the naming scheme used by [WrapFS] or [WrapFSManifest] to
+	// create the [FSWrapper], or, if it was created using [NewFSWrapper],
+	// to [UnderscoreNaming].
+	Naming NamingScheme
+
+	// Encoders are the encoders whose precompressed variants are served to
+	// clients accepting them, in order of preference.
+	// The client's preferences, as indicated by the q-values of its
+	// Accept-Encoding header, take precedence over this order.
+	//
+	// A precompressed variant of a file is a file with the same name and the
+	// encoder's suffix appended, e.g. "foo_1234.txt.gz", as written by
+	// [HashToDir] if [Options.Compression] is set.
+	// Variants of both the requested file and the file it maps to are
+	// served, so that the variants of files hashed using [WrapFS] can be
+	// served as well.
+	//
+	// Defaults to the registered encoders, see [Encoders].
+	Encoders []Encoder
+}
+
+func (o *HandlerOptions) setDefaults() {
+	if o.HashedCacheControl == "" {
+		o.HashedCacheControl = ImmutableCacheControl
+	}
+
+	if o.UnhashedCacheControl == "" {
+		o.UnhashedCacheControl = NoCacheControl
+	}
+
+	if o.Unhashed == 0 {
+		o.Unhashed = ActionServe
+	}
+
+	if o.Stale == 0 {
+		o.Stale = ActionNotFound
+	}
+
+	if o.StaleQuery == 0 {
+		o.StaleQuery = ActionServe
+	}
+
+	if o.RedirectCode == 0 {
+		o.RedirectCode = http.StatusFound
+	}
+
+	if o.Naming == nil {
+		o.Naming = UnderscoreNaming{}
+	}
+
+	if o.Encoders == nil {
+		o.Encoders = Encoders()
+	}
+}
+
+// Handler is an [http.Handler] that serves the files of an [FSWrapper] and
+// sets the Cache-Control header depending on whether a hashed file name was
+// requested.
+//
+// Requests of unhashed or outdated hashed names of files can be redirected to
+// the current hashed name, see [HandlerOptions.Unhashed] and
+// [HandlerOptions.Stale].
+//
+// Files hashed using [QueryNaming] are served under their original name, and
+// the Cache-Control header is only set to [HandlerOptions.HashedCacheControl],
+// if the query of the request matches the file's current hash, see
+// [HandlerOptions.StaleQuery].
+//
+// Hashed names of the previous generations added to the FSWrapper using
+// [FSWrapper.WithHistory] are always served, see there.
+//
+// If a file has precompressed variants, the variant preferred by the client,
+// as indicated by its Accept-Encoding header, is served instead, see
+// [HandlerOptions.Encoders].
+// The Content-Encoding header is set accordingly, and the Vary header lists
+// Accept-Encoding.
+// If an ETag header was set before calling the Handler, e.g. by a middleware,
+// the encoding is appended to it, so that conditional and range requests
+// keep working.
+//
+// Like [http.FileServer], it expects the requested file name to be the path
+// of the request's URL, so it should be used in conjunction with
+// [http.StripPrefix] if it is not served at the root.
+type Handler struct {
+	fsw        *FSWrapper
+	fileServer http.Handler
+	o          HandlerOptions
+}
+
+var _ http.Handler = (*Handler)(nil)
+
+// NewHandler creates a new [Handler] that serves the files of the passed
+// [FSWrapper] using the passed options.
+func NewHandler(fsw *FSWrapper, o HandlerOptions) *Handler {
+	if o.Naming == nil {
+		o.Naming = fsw.naming
+	}
+
+	o.setDefaults()
+
+	return &Handler{
+		fsw:        fsw,
+		fileServer: http.FileServer(http.FS(fsw)),
+		o:          o,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := requestName(r)
+	if h.fsw.IsHashed(name) {
+		w.Header().Set("Cache-Control", h.o.HashedCacheControl)
+		h.serveFile(w, r, name)
+		return
+	}
+
+	if h.fsw.IsPrevious(name) {
+		if _, err := fs.Stat(h.fsw.filesys, name); err == nil {
+			// the hashed file of the previous generation still exists
+			w.Header().Set("Cache-Control", h.o.HashedCacheControl)
+		} else {
+			// the current version of the file is served instead
+			w.Header().Set("Cache-Control", h.o.UnhashedCacheControl)
+		}
+
+		h.serveFile(w, r, name)
+		return
+	}
+
+	if hashedName, ok := h.fsw.m[name]; ok && hashedName != name {
+		if fp, query, ok := strings.Cut(hashedName, "?"); ok && fp == name {
+			h.handleQuery(w, r, name, hashedName, query)
+			return
+		}
+
+		h.handleOutdated(w, r, h.o.Unhashed, name, hashedName)
+		return
+	}
+
+	if _, err := fs.Stat(h.fsw.filesys, name); err != nil {
+		if origName, _, ok := h.o.Naming.Parse(name); ok {
+			if hashedName, ok := h.fsw.m[origName]; ok {
+				h.handleOutdated(w, r, h.o.Stale, name, hashedName)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Cache-Control", h.o.UnhashedCacheControl)
+	h.serveFile(w, r, name)
+}
+
+// handleQuery handles a request of name, whose hashed name, hashedName, only
+// differs by the passed query, as generated by [QueryNaming].
+func (h *Handler) handleQuery(w http.ResponseWriter, r *http.Request, name, hashedName, query string) {
+	want, err := url.ParseQuery(query)
+	if err != nil {
+		h.handleOutdated(w, r, h.o.StaleQuery, name, hashedName)
+		return
+	}
+
+	got := r.URL.Query()
+
+	var present bool
+	matches := true
+	for k := range want {
+		present = present || got.Has(k)
+		matches = matches && got.Get(k) == want.Get(k)
+	}
+
+	switch {
+	case matches:
+		w.Header().Set("Cache-Control", h.o.HashedCacheControl)
+		h.serveFile(w, r, name)
+	case present:
+		h.handleOutdated(w, r, h.o.StaleQuery, name, hashedName)
+	default:
+		h.handleOutdated(w, r, h.o.Unhashed, name, hashedName)
+	}
+}
+
+// handleOutdated handles a request of name, which is not the current hashed
+// name of its file, hashedName, by taking action a.
+func (h *Handler) handleOutdated(w http.ResponseWriter, r *http.Request, a Action, name, hashedName string) {
+	w.Header().Set("Cache-Control", h.o.UnhashedCacheControl)
+
+	switch a {
+	case ActionServe:
+		fp := filePath(hashedName)
+		h.serveFile(w, withPath(r, fp), fp)
+	case ActionRedirect:
+		http.Redirect(w, r, redirectURL(r, name, hashedName), h.o.RedirectCode)
+	case ActionNotFound:
+		http.NotFound(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveFile serves the file with the passed name, which is the name requested
+// by r, or, if the client accepts one of its precompressed variants, the
+// variant preferred by the client.
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	variant, enc, ok := h.variant(w, r, name)
+	if !ok {
+		h.fileServer.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(name)))
+	w.Header().Set("Content-Encoding", enc.ContentEncoding())
+
+	// each encoding is a different representation, and therefore needs a
+	// different entity tag, so that conditional and range requests keep
+	// working
+	if etag := w.Header().Get("ETag"); etag != "" {
+		w.Header().Set("ETag", variantETag(etag, enc.ContentEncoding()))
+	}
+
+	h.fileServer.ServeHTTP(w, withPath(r, variant))
+}
+
+// variant returns the name of the precompressed variant of the file with the
+// passed name, that should be served for r, and the encoder of the variant.
+//
+// Of the variants that exist, the one with the highest q-value in the
+// Accept-Encoding header of r is chosen.
+// If multiple variants have the same q-value, the encoder that comes first in
+// [HandlerOptions.Encoders] is preferred.
+// If the client explicitly prefers the identity encoding, or accepts none of
+// the variants, no variant is chosen.
+//
+// If the file has precompressed variants, it adds Accept-Encoding to the Vary
+// header.
+func (h *Handler) variant(w http.ResponseWriter, r *http.Request, name string) (string, Encoder, bool) {
+	// the Content-Type of variants can't be sniffed
+	if mime.TypeByExtension(path.Ext(name)) == "" {
+		return "", nil, false
+	}
+
+	names := []string{name}
+	if resolved := h.fsw.resolve(name); resolved != name {
+		names = append(names, resolved)
+	}
+
+	accepted := parseAcceptEncoding(r)
+
+	var (
+		hasVariants bool
+		best        string
+		bestEnc     Encoder
+		bestQ       float64
+	)
+	for _, enc := range h.o.Encoders {
+		for _, n := range names {
+			stat, err := fs.Stat(h.fsw.filesys, n+enc.Suffix())
+			if err != nil || stat.IsDir() {
+				continue
+			}
+
+			hasVariants = true
+
+			if q := accepted.q(enc.ContentEncoding(), 0); q > bestQ {
+				best, bestEnc, bestQ = n+enc.Suffix(), enc, q
+			}
+
+			break
+		}
+	}
+
+	if !hasVariants {
+		return "", nil, false
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	// identity is only preferred, if the client explicitly says so
+	if bestEnc == nil || accepted.q("identity", 0) > bestQ {
+		return "", nil, false
+	}
+
+	return best, bestEnc, true
+}
+
+// acceptEncoding maps the lower-cased content codings listed in an
+// Accept-Encoding header to their q-values.
+type acceptEncoding map[string]float64
+
+// parseAcceptEncoding parses the Accept-Encoding headers of r.
+// Codings with invalid q-values are ignored.
+func parseAcceptEncoding(r *http.Request) acceptEncoding {
+	accepted := make(acceptEncoding)
+
+	for _, header := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(header, ",") {
+			coding, params, _ := strings.Cut(coding, ";")
+
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" {
+				continue
+			}
+
+			q := 1.0
+			for _, param := range strings.Split(params, ";") {
+				k, v, _ := strings.Cut(param, "=")
+				if !strings.EqualFold(strings.TrimSpace(k), "q") {
+					continue
+				}
+
+				var err error
+				q, err = strconv.ParseFloat(strings.TrimSpace(v), 64)
+				if err != nil || q < 0 || q > 1 {
+					q = -1
+				}
+			}
+
+			if q >= 0 {
+				accepted[coding] = q
+			}
+		}
+	}
+
+	return accepted
+}
+
+// q returns the q-value of the passed content coding.
+// If the coding is not listed, the q-value of "*" is returned, or, if that is
+// not listed either, def.
+func (a acceptEncoding) q(coding string, def float64) float64 {
+	if q, ok := a[strings.ToLower(coding)]; ok {
+		return q
+	}
+
+	if q, ok := a["*"]; ok {
+		return q
+	}
+
+	return def
+}
+
+// variantETag returns the entity tag of the variant with the passed encoding
+// of the file with the passed entity tag.
+func variantETag(etag, encoding string) string {
+	if !strings.HasSuffix(etag, `"`) || len(etag) < 2 {
+		return etag
+	}
+
+	return etag[:len(etag)-1] + "-" + encoding + `"`
+}
+
+// withPath returns a shallow copy of r, that requests the file with the
+// passed name.
+func withPath(r *http.Request, name string) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = "/" + name
+	r2.URL.RawPath = ""
+
+	return r2
+}
+
+// requestName returns the name of the file requested by r, in the format
+// expected by [fs.FS].
+func requestName(r *http.Request) string {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		return "."
+	}
+
+	return name
+}
+
+// redirectURL returns the URL of r, with the requested file name replaced by
+// target.
+// If target has a query, as generated by [QueryNaming], its parameters replace
+// those of r.
+//
+// Unlike r.URL, it preserves prefixes stripped by [http.StripPrefix].
+func redirectURL(r *http.Request, name, target string) string {
+	p := r.URL.Path
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil && strings.HasSuffix(u.Path, name) {
+		p = u.Path
+	}
+
+	// the prefix is cleaned, so that the path starts with exactly one slash,
+	// as "//host/..." would be a redirect to another host
+	prefix := path.Clean("/" + strings.TrimSuffix(p, name))
+	if prefix != "/" {
+		prefix += "/"
+	}
+
+	target, targetQuery, hasQuery := strings.Cut(target, "?")
+
+	u := url.URL{Path: prefix + target, RawQuery: r.URL.RawQuery}
+	if hasQuery {
+		q := r.URL.Query()
+		tq, _ := url.ParseQuery(targetQuery)
+		for k, v := range tq {
+			q[k] = v
+		}
+
+		u.RawQuery = q.Encode()
+	}
+
+	return u.String()
+}
@@ -0,0 +1,495 @@
+package hashets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	fsw, _, err := WrapFS(testdataIn, Options{Ignore: IgnorePrefix("folder")})
+	require.NoError(t, err)
+
+	h := NewHandler(fsw, HandlerOptions{UnhashedCacheControl: "max-age=60"})
+
+	cases := []struct {
+		name         string
+		path         string
+		expectStatus int
+		expectCache  string
+	}{
+		{
+			name:         "hashed",
+			path:         "/" + expectMap["foo"],
+			expectStatus: http.StatusOK,
+			expectCache:  ImmutableCacheControl,
+		},
+		{
+			name:         "hashed with space",
+			path:         "/" + expectMap["bee movie.txt"],
+			expectStatus: http.StatusOK,
+			expectCache:  ImmutableCacheControl,
+		},
+		{
+			name:         "original",
+			path:         "/foo",
+			expectStatus: http.StatusOK,
+			expectCache:  "max-age=60",
+		},
+		{
+			name:         "ignored",
+			path:         "/folder/maja.webp",
+			expectStatus: http.StatusOK,
+			expectCache:  "max-age=60",
+		},
+		{
+			name:         "not found",
+			path:         "/bar",
+			expectStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, (&url.URL{Path: c.path}).String(), nil))
+
+			assert.Equal(t, c.expectStatus, rec.Code)
+			if c.expectCache != "" {
+				assert.Equal(t, c.expectCache, rec.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
+
+func TestHandler_Outdated(t *testing.T) {
+	t.Parallel()
+
+	fsw, _, err := WrapFS(testdataIn, Options{})
+	require.NoError(t, err)
+
+	cases := []struct {
+		name string
+		o    HandlerOptions
+		path string
+
+		expectStatus   int
+		expectLocation string
+	}{
+		{
+			name:         "unhashed default",
+			path:         "/foo",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:           "unhashed redirect",
+			o:              HandlerOptions{Unhashed: ActionRedirect},
+			path:           "/foo",
+			expectStatus:   http.StatusFound,
+			expectLocation: "/" + expectMap["foo"],
+		},
+		{
+			name:         "unhashed not found",
+			o:            HandlerOptions{Unhashed: ActionNotFound},
+			path:         "/foo",
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "stale default",
+			path:         "/foo_1234",
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "stale serve",
+			o:            HandlerOptions{Stale: ActionServe},
+			path:         "/folder/maja_1234.webp",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:           "stale redirect",
+			o:              HandlerOptions{Stale: ActionRedirect, RedirectCode: http.StatusMovedPermanently},
+			path:           "/folder/maja_1234.webp",
+			expectStatus:   http.StatusMovedPermanently,
+			expectLocation: "/" + expectMap["folder/maja.webp"],
+		},
+		{
+			name:           "stale redirect with space",
+			o:              HandlerOptions{Stale: ActionRedirect},
+			path:           "/bee movie_1234.txt",
+			expectStatus:   http.StatusFound,
+			expectLocation: (&url.URL{Path: "/" + expectMap["bee movie.txt"]}).String(),
+		},
+		{
+			name:         "unknown",
+			o:            HandlerOptions{Stale: ActionRedirect},
+			path:         "/bar_1234",
+			expectStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := NewHandler(fsw, c.o)
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, (&url.URL{Path: c.path}).String(), nil))
+
+			assert.Equal(t, c.expectStatus, rec.Code)
+			assert.Equal(t, c.expectLocation, rec.Header().Get("Location"))
+			if rec.Code != http.StatusNotFound {
+				assert.Equal(t, NoCacheControl, rec.Header().Get("Cache-Control"))
+			}
+		})
+	}
+
+	t.Run("strip prefix", func(t *testing.T) {
+		t.Parallel()
+
+		h := http.StripPrefix("/static", NewHandler(fsw, HandlerOptions{Unhashed: ActionRedirect}))
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/static/foo?a=b", nil))
+
+		assert.Equal(t, http.StatusFound, rec.Code)
+		assert.Equal(t, "/static/"+expectMap["foo"]+"?a=b", rec.Header().Get("Location"))
+	})
+
+	t.Run("no other host", func(t *testing.T) {
+		t.Parallel()
+
+		h := NewHandler(fsw, HandlerOptions{Unhashed: ActionRedirect})
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "//folder/maja.webp", nil))
+
+		assert.Equal(t, http.StatusFound, rec.Code)
+		assert.Equal(t, "/"+expectMap["folder/maja.webp"], rec.Header().Get("Location"))
+	})
+}
+
+func TestHandler_Variants(t *testing.T) {
+	t.Parallel()
+
+	filesys := fstest.MapFS{
+		"foo.txt":    {Data: []byte("foo")},
+		"foo.txt.gz": {Data: []byte("gzip")},
+		"foo.txt.up": {Data: []byte("FOO")},
+		"bar.txt":    {Data: []byte("bar")},
+	}
+
+	fsw, m, err := WrapFS(filesys, Options{})
+	require.NoError(t, err)
+
+	cases := []struct {
+		name           string
+		o              HandlerOptions
+		path           string
+		acceptEncoding string
+
+		expectBody     string
+		expectEncoding string
+		expectVary     string
+	}{
+		{
+			name:           "gzip",
+			path:           "/" + m["foo.txt"],
+			acceptEncoding: "br, gzip;q=0.8",
+			expectBody:     "gzip",
+			expectEncoding: "gzip",
+			expectVary:     "Accept-Encoding",
+		},
+		{
+			name:           "unhashed",
+			path:           "/foo.txt",
+			acceptEncoding: "GZIP",
+			expectBody:     "gzip",
+			expectEncoding: "gzip",
+			expectVary:     "Accept-Encoding",
+		},
+		{
+			name:       "not accepted",
+			path:       "/" + m["foo.txt"],
+			expectBody: "foo",
+			expectVary: "Accept-Encoding",
+		},
+		{
+			name:           "no variants",
+			path:           "/" + m["bar.txt"],
+			acceptEncoding: "gzip",
+			expectBody:     "bar",
+		},
+		{
+			name:           "rejected",
+			path:           "/" + m["foo.txt"],
+			acceptEncoding: "gzip;q=0, *",
+			expectBody:     "foo",
+			expectVary:     "Accept-Encoding",
+		},
+		{
+			name:           "wildcard",
+			path:           "/" + m["foo.txt"],
+			acceptEncoding: "*",
+			expectBody:     "gzip",
+			expectEncoding: "gzip",
+			expectVary:     "Accept-Encoding",
+		},
+		{
+			name:           "identity preferred",
+			path:           "/" + m["foo.txt"],
+			acceptEncoding: "identity, gzip;q=0.5",
+			expectBody:     "foo",
+			expectVary:     "Accept-Encoding",
+		},
+		{
+			name:           "q-values",
+			o:              HandlerOptions{Encoders: []Encoder{GzipEncoder{}, upperEncoder{}}},
+			path:           "/" + m["foo.txt"],
+			acceptEncoding: "gzip;q=0.5, x-upper;q=0.9",
+			expectBody:     "FOO",
+			expectEncoding: "x-upper",
+			expectVary:     "Accept-Encoding",
+		},
+		{
+			name:           "invalid q-value",
+			path:           "/" + m["foo.txt"],
+			acceptEncoding: "gzip;q=2",
+			expectBody:     "foo",
+			expectVary:     "Accept-Encoding",
+		},
+		{
+			name:           "custom encoder",
+			o:              HandlerOptions{Encoders: []Encoder{upperEncoder{}, GzipEncoder{}}},
+			path:           "/" + m["foo.txt"],
+			acceptEncoding: "gzip, x-upper",
+			expectBody:     "FOO",
+			expectEncoding: "x-upper",
+			expectVary:     "Accept-Encoding",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := NewHandler(fsw, c.o)
+
+			req := httptest.NewRequest(http.MethodGet, (&url.URL{Path: c.path}).String(), nil)
+			if c.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", c.acceptEncoding)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, c.expectBody, rec.Body.String())
+			assert.Equal(t, c.expectEncoding, rec.Header().Get("Content-Encoding"))
+			assert.Equal(t, c.expectVary, rec.Header().Get("Vary"))
+			assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestHandler_VariantsConditional(t *testing.T) {
+	t.Parallel()
+
+	modTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	filesys := fstest.MapFS{
+		"foo.txt":    {Data: []byte("foo"), ModTime: modTime},
+		"foo.txt.gz": {Data: []byte("gzip"), ModTime: modTime},
+	}
+
+	fsw, m, err := WrapFS(filesys, Options{})
+	require.NoError(t, err)
+
+	h := NewHandler(fsw, HandlerOptions{})
+	withETag := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"1234"`)
+		h.ServeHTTP(w, r)
+	})
+
+	cases := []struct {
+		name    string
+		handler http.Handler
+		header  http.Header
+
+		expectStatus int
+		expectBody   string
+		expectETag   string
+	}{
+		{
+			name:         "range",
+			handler:      h,
+			header:       http.Header{"Range": {"bytes=1-2"}},
+			expectStatus: http.StatusPartialContent,
+			expectBody:   "zi",
+		},
+		{
+			name:         "if-modified-since",
+			handler:      h,
+			header:       http.Header{"If-Modified-Since": {modTime.Format(http.TimeFormat)}},
+			expectStatus: http.StatusNotModified,
+		},
+		{
+			name:         "etag",
+			handler:      withETag,
+			expectStatus: http.StatusOK,
+			expectBody:   "gzip",
+			expectETag:   `"1234-gzip"`,
+		},
+		{
+			name:         "if-none-match",
+			handler:      withETag,
+			header:       http.Header{"If-None-Match": {`"1234-gzip"`}},
+			expectStatus: http.StatusNotModified,
+			expectETag:   `"1234-gzip"`,
+		},
+		{
+			name:         "if-none-match other encoding",
+			handler:      withETag,
+			header:       http.Header{"If-None-Match": {`"1234"`}},
+			expectStatus: http.StatusOK,
+			expectBody:   "gzip",
+			expectETag:   `"1234-gzip"`,
+		},
+		{
+			name:         "if-range",
+			handler:      withETag,
+			header:       http.Header{"Range": {"bytes=0-1"}, "If-Range": {`"1234-gzip"`}},
+			expectStatus: http.StatusPartialContent,
+			expectBody:   "gz",
+			expectETag:   `"1234-gzip"`,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "/"+m["foo.txt"], nil)
+			for k, v := range c.header {
+				req.Header[k] = v
+			}
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			rec := httptest.NewRecorder()
+			c.handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, c.expectStatus, rec.Code)
+			assert.Equal(t, c.expectBody, rec.Body.String())
+			assert.Equal(t, c.expectETag, rec.Header().Get("ETag"))
+			// net/http removes Content-Encoding from 304 responses
+			if rec.Code != http.StatusNotModified {
+				assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+			}
+		})
+	}
+}
+
+func TestHandler_Naming(t *testing.T) {
+	t.Parallel()
+
+	fsw, m, err := WrapFS(testdataIn, Options{Naming: DotNaming{}})
+	require.NoError(t, err)
+
+	// the naming scheme defaults to the one the FSWrapper was created with
+	for _, o := range []HandlerOptions{{Naming: DotNaming{}, Stale: ActionRedirect}, {Stale: ActionRedirect}} {
+		h := NewHandler(fsw, o)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/folder/maja.1234.webp", nil))
+
+		assert.Equal(t, http.StatusFound, rec.Code)
+		assert.Equal(t, "/"+m["folder/maja.webp"], rec.Header().Get("Location"))
+	}
+}
+
+func TestHandler_QueryNaming(t *testing.T) {
+	t.Parallel()
+
+	fsw, m, err := WrapFS(testdataIn, Options{
+		Naming: MatchNaming{Match: func(p string) bool { return p == "foo" }, Matched: QueryNaming{}},
+	})
+	require.NoError(t, err)
+
+	_, query, ok := strings.Cut(m["foo"], "?")
+	require.True(t, ok)
+
+	cases := []struct {
+		name string
+		o    HandlerOptions
+		url  string
+
+		expectStatus       int
+		expectCacheControl string
+		expectLocation     string
+	}{
+		{
+			name:               "current",
+			url:                "/foo?a=b&" + query,
+			expectStatus:       http.StatusOK,
+			expectCacheControl: ImmutableCacheControl,
+		},
+		{
+			name:               "stale default",
+			url:                "/foo?v=1234",
+			expectStatus:       http.StatusOK,
+			expectCacheControl: NoCacheControl,
+		},
+		{
+			name:               "stale redirect",
+			o:                  HandlerOptions{StaleQuery: ActionRedirect},
+			url:                "/foo?a=b&v=1234",
+			expectStatus:       http.StatusFound,
+			expectCacheControl: NoCacheControl,
+			expectLocation:     "/foo?a=b&" + query,
+		},
+		{
+			name:               "unhashed default",
+			url:                "/foo",
+			expectStatus:       http.StatusOK,
+			expectCacheControl: NoCacheControl,
+		},
+		{
+			name:               "unhashed redirect",
+			o:                  HandlerOptions{Unhashed: ActionRedirect},
+			url:                "/foo",
+			expectStatus:       http.StatusFound,
+			expectCacheControl: NoCacheControl,
+			expectLocation:     "/" + m["foo"],
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := NewHandler(fsw, c.o)
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.url, nil))
+
+			assert.Equal(t, c.expectStatus, rec.Code)
+			assert.Equal(t, c.expectCacheControl, rec.Header().Get("Cache-Control"))
+			assert.Equal(t, c.expectLocation, rec.Header().Get("Location"))
+		})
+	}
+}
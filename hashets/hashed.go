@@ -0,0 +1,155 @@
+package hashets
+
+import (
+	"context"
+	"io/fs"
+	"sort"
+)
+
+// recognizeHashed recognizes the files at the passed paths that are the
+// hashed equivalents of other files, e.g. because they were written by a
+// previous run of [HashToDir] into the directory it hashes.
+//
+// A file is recognized as hashed file, if its path can be parsed by
+// [Options.Naming], and if it carries a valid hash for its contents.
+// If the hash of the original file may include the hashes of the files it
+// references, i.e. if the original is matched by a [ReferenceExtractor], the
+// hash cannot be verified, and the hashed file is recognized if the original
+// file is still present.
+//
+// recognizeHashed returns the paths of the files that are not hashed files.
+// Hashed files whose originals are still present are skipped, and added to
+// skipped, as are the precompressed variants of all hashed files.
+// For the hashed files whose originals are not present anymore, e.g. because
+// they were replaced by their hashed equivalents, a file is added to
+// prehashed, describing the original file, if the original path can be
+// recovered from the hashed path.
+// If there are multiple such hashed files for the same original, only the most
+// recently modified one is used.
+func recognizeHashed(
+	ctx context.Context, inFS fs.FS, paths []string, o Options,
+) (remaining []string, prehashed []*file, skipped map[string]struct{}, err error) {
+	hashLen := o.hashLen()
+
+	pathSet := make(map[string]struct{}, len(paths))
+	for _, p := range paths {
+		pathSet[p] = struct{}{}
+	}
+
+	type candidate struct {
+		path, orig, hash string
+
+		recognized bool
+		// origPresent is true, if the original file is in paths.
+		origPresent bool
+	}
+
+	var candidates []*candidate
+	for _, p := range paths {
+		if orig, hash, ok := o.parseHashedPath(p, hashLen); ok {
+			c := &candidate{path: p, orig: orig, hash: hash}
+			_, c.origPresent = pathSet[c.orig]
+			candidates = append(candidates, c)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return paths, nil, nil, nil
+	}
+
+	err = forEach(ctx, len(candidates), o.Concurrency, func(i int) error {
+		c := candidates[i]
+
+		// read errors are reported when hashing the file regularly
+		digest, err := contentDigest(ctx, inFS, c.path, o)
+		if err != nil {
+			return ctx.Err()
+		}
+
+		if o.HashToText(digest) == c.hash {
+			c.recognized = true
+			return nil
+		}
+
+		// the hashes of the files the original references may be folded
+		// into its hash, and both may have changed since
+		c.recognized = c.origPresent && o.extractor(c.orig) != nil
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	skipped = make(map[string]struct{}, len(candidates))
+	newest := make(map[string]*candidate)
+
+	for _, c := range candidates {
+		if !c.recognized {
+			continue
+		}
+
+		skipped[c.path] = struct{}{}
+		if c.origPresent || c.orig == "" {
+			continue
+		}
+
+		if prev, ok := newest[c.orig]; ok && !isNewer(inFS, c.path, prev.path) {
+			continue
+		}
+
+		newest[c.orig] = c
+	}
+
+	// the precompressed variants of hashed files are skipped as well
+	hashed := make(map[string]struct{}, len(skipped))
+	for p := range skipped {
+		hashed[p] = struct{}{}
+	}
+
+	suffixes := o.variantSuffixes()
+	for _, p := range paths {
+		if isVariant(p, hashed, suffixes) {
+			skipped[p] = struct{}{}
+		}
+	}
+
+	remaining = make([]string, 0, len(paths)-len(skipped))
+	for _, p := range paths {
+		if _, ok := skipped[p]; !ok {
+			remaining = append(remaining, p)
+		}
+	}
+
+	prehashed = make([]*file, 0, len(newest))
+	for _, c := range newest {
+		prehashed = append(prehashed, &file{path: c.orig, src: c.path})
+	}
+
+	sort.Slice(prehashed, func(i, j int) bool { return prehashed[i].path < prehashed[j].path })
+	return remaining, prehashed, skipped, nil
+}
+
+// contentDigest returns the digest of the contents of the file at p.
+func contentDigest(ctx context.Context, inFS fs.FS, p string, o Options) ([]byte, error) {
+	h := o.NewHash()
+	h.Reset()
+
+	if err := copyFile(ctx, h, inFS, p); err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+// isNewer reports whether the file at a was modified after the file at b.
+// If both were modified at the same time, the file with the greater path is
+// considered newer.
+func isNewer(inFS fs.FS, a, b string) bool {
+	statA, errA := fs.Stat(inFS, a)
+	statB, errB := fs.Stat(inFS, b)
+	if errA != nil || errB != nil || statA.ModTime().Equal(statB.ModTime()) {
+		return a > b
+	}
+
+	return statA.ModTime().After(statB.ModTime())
+}
@@ -2,13 +2,17 @@
 package hashets
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 // Map represents a map of file paths to file paths with hashed names.
@@ -26,72 +30,117 @@ type Map map[string]string
 // production, but are left unhashed in development.
 //
 // See the readme of this repository for an example of such a case.
+//
+// Leading "/" and "./" are ignored, see [Map.Lookup].
+// If m is not nil, but the file path is unknown, Get returns an empty
+// string.
+// Use [Map.Lookup], [Map.MustGet], or a [Resolver] to handle unknown file
+// paths differently.
 func (m Map) Get(name string) string {
-	if m == nil {
-		return name
+	hashed, _ := m.Lookup(name)
+	return hashed
+}
+
+// Merge merges the passed maps into a single [Map].
+//
+// If two maps map the same file path to different hashed file paths, Merge
+// returns an error.
+func Merge(ms ...Map) (Map, error) {
+	var n int
+	for _, m := range ms {
+		n += len(m)
 	}
 
-	return m[name]
+	merged := make(Map, n)
+	for _, m := range ms {
+		for name, hashed := range m {
+			if prev, ok := merged[name]; ok && prev != hashed {
+				return nil, fmt.Errorf("conflicting hashed names for %s: %s and %s", name, prev, hashed)
+			}
+
+			merged[name] = hashed
+		}
+	}
+
+	return merged, nil
 }
 
 // Hash takes the given [fs.FS], hashes all its files using the options
 // provided, and returns a [Map] that maps the original file path to the
 // same path, but with the file name replaced with the hashed file name, as
-// returned by [Options.NamingFunc].
+// returned by [Options.Naming].
+//
+// If [Options.Extractors] or [Options.Rewriters] find references between
+// files, files are hashed after the files they reference, and the hashes of
+// the referenced files are folded into the hash of the referencing file.
 func Hash(inFS fs.FS, o Options) (Map, error) {
+	return HashContext(context.Background(), inFS, o)
+}
+
+// HashContext works like [Hash], but stops hashing as soon as ctx is
+// cancelled, in which case it returns ctx.Err().
+func HashContext(ctx context.Context, inFS fs.FS, o Options) (Map, error) {
+	man, err := hashFS(ctx, inFS, o, "")
+	return man.Map(), err
+}
+
+// hashFS implements [HashContext] and [HashManifest].
+//
+// If sri is not empty, it additionally computes the SRI strings of the files
+// using the given algorithm.
+func hashFS(ctx context.Context, inFS fs.FS, o Options, sri SRIAlgorithm) (Manifest, error) {
 	o.setDefaults()
 
-	m := make(Map)
-	err := fs.WalkDir(inFS, ".", func(p string, dir fs.DirEntry, _ error) error {
-		p = strings.TrimPrefix(p, "./")
-		if dir == nil || dir.IsDir() {
-			return nil
-		}
+	errs := errorCollector{collect: o.CollectErrors}
 
-		if o.Ignore(p) {
-			return nil
+	var paths, ignored []string
+	err := fs.WalkDir(inFS, ".", func(p string, dir fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
-		in, err := inFS.Open(p)
+		p = strings.TrimPrefix(p, "./")
 		if err != nil {
-			return err
+			return errs.handle(ctx, p, err)
 		}
 
-		o.Hash.Reset()
-		_, err = io.Copy(o.Hash, in)
-		if err != nil {
-			return err
+		if dir.IsDir() {
+			if p == metaDir {
+				return fs.SkipDir
+			}
+
+			return nil
 		}
 
-		if err := in.Close(); err != nil {
-			return err
+		if o.Ignore(p) {
+			ignored = append(ignored, p)
+			return nil
 		}
 
-		name := path.Base(p)
-		hash := o.Hash.Sum(nil)
-		m[p] = p[:len(p)-len(name)] + o.NamingFunc(name, o.HashToText(hash))
+		paths = append(paths, p)
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return m, nil
+	return hashFiles(ctx, inFS, paths, ignored, o, nil, sri, &errs)
 }
 
 // HashFile takes the given [io.Reader], calculates the hash of its contents
-// and returns the hashed file name, as returned by [Options.NamingFunc], using
+// and returns the hashed file name, as returned by [Options.Naming], using
 // name as the original file name.
 func HashFile(name string, in io.Reader, o Options) (string, error) {
 	o.setDefaults()
 
-	o.Hash.Reset()
-	if _, err := io.Copy(o.Hash, in); err != nil {
+	h := o.NewHash()
+	h.Reset()
+	if _, err := io.Copy(h, in); err != nil {
 		return "", err
 	}
 
-	hash := o.Hash.Sum(nil)
-	return o.NamingFunc(name, o.HashToText(hash)), nil
+	hash := h.Sum(nil)
+	return o.Naming.Format(name, o.HashToText(hash)), nil
 }
 
 // HashToTempDir takes the given [fs.FS], hashes all its files using the options
@@ -106,13 +155,23 @@ func HashFile(name string, in io.Reader, o Options) (string, error) {
 // If HashToTempDir has created the temporary directory, but returns an error,
 // the temporary directory will have been removed by HashToTempDir itself, and
 // the caller need not call the cleanup function.
+// The only exception to this are the [FileErrors] returned, if
+// [Options.CollectErrors] is set, which are returned alongside the results
+// for the files that could be hashed.
 // However, the cleanup will never be nil, so it is safe to call it even if
 // an error was returned.
 //
 // The returned [Map] provides mappings from the original file path to the same
 // path, but with the file name replaced with the hashed file name, as returned
-// by [Options.NamingFunc].
+// by [Options.Naming].
 func HashToTempDir(inFS fs.FS, o Options) (_ fs.FS, _ Map, cleanup func() error, _ error) {
+	return HashToTempDirContext(context.Background(), inFS, o)
+}
+
+// HashToTempDirContext works like [HashToTempDir], but stops hashing as soon
+// as ctx is cancelled, in which case it removes the temporary directory and
+// returns ctx.Err().
+func HashToTempDirContext(ctx context.Context, inFS fs.FS, o Options) (_ fs.FS, _ Map, cleanup func() error, _ error) {
 	cleanup = func() error { return nil }
 
 	outPath, err := os.MkdirTemp("", "hashets")
@@ -122,13 +181,14 @@ func HashToTempDir(inFS fs.FS, o Options) (_ fs.FS, _ Map, cleanup func() error,
 
 	cleanup = func() error { return os.RemoveAll(outPath) }
 
-	m, err := HashToDir(inFS, outPath, o)
-	if err != nil {
+	m, err := HashToDirContext(ctx, inFS, outPath, o)
+	if m == nil {
 		_ = cleanup()
 		return nil, nil, func() error { return nil }, err
 	}
 
-	return os.DirFS(outPath), m, cleanup, nil
+	// if Options.CollectErrors is set, m and err may both be non-nil
+	return os.DirFS(outPath), m, cleanup, err
 }
 
 // HashToDir takes the given [fs.FS], hashes all its files using the options
@@ -136,23 +196,93 @@ func HashToTempDir(inFS fs.FS, o Options) (_ fs.FS, _ Map, cleanup func() error,
 //
 // The returned [Map] provides mappings from the original file path to the same
 // path, but with the file name replaced with the hashed file name, as returned
-// by [Options.NamingFunc].
+// by [Options.Naming].
+//
+// Files matched by one of [Options.Rewriters] are hashed and written only
+// after their references to other files have been rewritten.
+// Otherwise, references found by [Options.Extractors] are handled as
+// described in [Hash].
 //
 // It is explicitly allowed for the output directory to match the input [fs.FS].
 // That means HashToDir(os.DirFS("/some/path"), "/some/path", o) is valid and
 // will work as expected.
+//
+// Files that are the hashed equivalents of other files, e.g. because they
+// were written by a previous call to HashToDir with the same input and output
+// directory, are recognized and not hashed again, so that HashToDir can be
+// called repeatedly.
+// If the original file of such a hashed file is not present anymore, e.g.
+// because it was replaced by its hashed equivalent, the hashed file is
+// included in the returned Map as is.
+// This is not possible for files whose hashes include the hashes of the files
+// they reference, see [Options.Extractors].
 func HashToDir(inFS fs.FS, outPath string, o Options) (Map, error) {
+	return HashToDirContext(context.Background(), inFS, outPath, o)
+}
+
+// HashToDirContext works like [HashToDir], but stops hashing as soon as ctx
+// is cancelled, in which case it returns ctx.Err().
+//
+// On cancellation, the files and directories created in the output directory
+// up to that point are removed again.
+// Files that existed before are left as is.
+func HashToDirContext(ctx context.Context, inFS fs.FS, outPath string, o Options) (Map, error) {
+	man, err := hashFSToDir(ctx, inFS, outPath, o, "")
+	return man.Map(), err
+}
+
+// hashFSToDir implements [HashToDirContext] and [HashToDirManifest].
+//
+// If sri is not empty, it additionally computes the SRI strings of the
+// written files using the given algorithm.
+func hashFSToDir(ctx context.Context, inFS fs.FS, outPath string, o Options, sri SRIAlgorithm) (Manifest, error) {
 	o.setDefaults()
 
-	m := make(Map)
+	if o.Compression != nil {
+		if err := o.Compression.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	out := outDir{path: outPath, compression: o.Compression, inPlace: isDir(inFS, outPath)}
+
+	man, err := hashToOutDir(ctx, inFS, &out, o, sri)
+	if err != nil && ctx.Err() != nil {
+		out.removeCreated()
+	}
+
+	return man, err
+}
+
+// hashToOutDir hashes the files of inFS and writes them to out.
+func hashToOutDir(ctx context.Context, inFS fs.FS, out *outDir, o Options, sri SRIAlgorithm) (Manifest, error) {
+	errs := errorCollector{collect: o.CollectErrors}
+
+	var paths, ignored []string
 	err := fs.WalkDir(inFS, ".", func(path string, dir fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		path = strings.TrimPrefix(path, "./")
-		if dir == nil || dir.IsDir() {
-			if dir != nil && dir.IsDir() && path != "." {
-				if err := os.Mkdir(outPath+"/"+path, 0o755); err != nil {
-					if !errors.Is(err, os.ErrExist) {
+		if err != nil {
+			return errs.handle(ctx, path, err)
+		}
+
+		if dir.IsDir() {
+			if path == metaDir {
+				return fs.SkipDir
+			}
+
+			// if the naming scheme restructures the output, the directories
+			// of the hashed files are created when writing them
+			if path != "." && o.keepsDirs() {
+				if err := out.mkdir(path); err != nil {
+					if err := errs.handle(ctx, path, err); err != nil {
 						return err
 					}
+
+					return fs.SkipDir
 				}
 			}
 
@@ -160,70 +290,178 @@ func HashToDir(inFS fs.FS, outPath string, o Options) (Map, error) {
 		}
 
 		if o.Ignore(path) {
+			ignored = append(ignored, path)
 			return nil
 		}
 
-		return writeHashed(inFS, path, outPath, m, o)
+		paths = append(paths, path)
+		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return m, nil
+	return hashFiles(ctx, inFS, paths, ignored, o, out.writeFile, sri, &errs)
 }
 
 // ============================================================================
 // Utils
 // ======================================================================================
 
-func writeHashed(inFS fs.FS, inPath, outPath string, m Map, o Options) error {
-	in, err := inFS.Open(inPath)
-	if err != nil {
+// outDir is an output directory, that keeps track of the directories and
+// files created in it, so that they can be removed again.
+type outDir struct {
+	path        string
+	compression *CompressionOptions
+	// inPlace is true, if path is the directory of the hashed fs.FS.
+	inPlace bool
+
+	mu sync.Mutex
+	// created are the paths of the created directories and files, relative
+	// to path, in the order they were created.
+	created []string
+}
+
+// mkdir creates the directory at p, if it doesn't exist yet.
+func (d *outDir) mkdir(p string) error {
+	if err := os.Mkdir(filepath.Join(d.path, p), 0o755); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil
+		}
+
 		return err
 	}
 
-	o.Hash.Reset()
-	_, err = io.Copy(o.Hash, in)
-	if err != nil {
-		return err
+	d.addCreated(p)
+	return nil
+}
+
+// mkdirAll creates the directory at p and its parents, if they don't exist
+// yet.
+func (d *outDir) mkdirAll(p string) error {
+	if p == "." {
+		return nil
 	}
 
-	if err := in.Close(); err != nil {
+	if err := d.mkdirAll(path.Dir(p)); err != nil {
 		return err
 	}
 
-	name := path.Base(inPath)
-	hash := o.Hash.Sum(nil)
-	hashedPath := inPath[:len(inPath)-len(name)] + o.NamingFunc(name, o.HashToText(hash))
-	m[inPath] = hashedPath
+	return d.mkdir(p)
+}
 
-	in, err = inFS.Open(inPath)
-	if err != nil {
-		return err
+// writeFile writes the hashed file described by e, reading the contents from
+// r, and its precompressed variants.
+//
+// If keepExisting is true, and the file already exists, it is left as is.
+func (d *outDir) writeFile(e Entry, r io.Reader, keepExisting bool) ([]Variant, error) {
+	fp := filePath(e.HashedPath)
+
+	// the naming scheme may place hashed files in their own directories
+	if err := d.mkdirAll(path.Dir(fp)); err != nil {
+		return nil, err
 	}
 
-	stat, err := in.Stat()
+	p := filepath.Join(d.path, filepath.FromSlash(fp))
+
+	_, err := os.Lstat(p)
+	existed := err == nil
+
+	// files keeping their path, e.g. with QueryNaming, are their own hashed
+	// files when hashing in place
+	if existed && !keepExisting && fp == e.Path {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+
+		existing, err := os.ReadFile(p)
+		keepExisting = err == nil && bytes.Equal(existing, data)
+		r = bytes.NewReader(data)
+
+		// the rewritten file would replace its own original
+		if !keepExisting && d.inPlace {
+			return nil, fmt.Errorf("cannot write rewritten file %s in place, "+
+				"since its hashed file has the same path; use a separate output directory", e.Path)
+		}
+	}
+
+	if !existed || !keepExisting {
+		if err := writeFile(p, r, e.Mode); err != nil {
+			_ = os.Remove(p)
+			return nil, err
+		}
+
+		if !existed {
+			d.addCreated(fp)
+		}
+	}
+
+	return writeVariants(p, e, d.compression, keepExisting, d.addCreated)
+}
+
+func (d *outDir) addCreated(p string) {
+	d.mu.Lock()
+	d.created = append(d.created, p)
+	d.mu.Unlock()
+}
+
+// removeCreated removes the directories and files created in d, on a
+// best-effort basis.
+func (d *outDir) removeCreated() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// directories are created before the files they contain, so that
+	// removing in reverse order removes them after their contents
+	for i := len(d.created) - 1; i >= 0; i-- {
+		_ = os.Remove(filepath.Join(d.path, d.created[i]))
+	}
+
+	d.created = nil
+}
+
+// isDir reports whether inFS is the directory at p, e.g. because it was
+// created using os.DirFS(p).
+func isDir(inFS fs.FS, p string) bool {
+	inStat, err := fs.Stat(inFS, ".")
 	if err != nil {
-		return err
+		return false
 	}
 
-	out, err := os.OpenFile(filepath.Join(outPath, hashedPath), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, stat.Mode()&0o555)
+	outStat, err := os.Stat(p)
 	if err != nil {
-		return err
+		return false
 	}
 
-	_, err = io.Copy(out, in)
+	return os.SameFile(inStat, outStat)
+}
+
+// writeFile writes the contents of r to the file at p, replacing it, if it
+// exists.
+func writeFile(p string, r io.Reader, mode fs.FileMode) error {
+	out, err := createFile(p, mode)
 	if err != nil {
 		return err
 	}
 
-	if err := in.Close(); err != nil {
+	if _, err := io.Copy(out, r); err != nil {
+		_ = out.Close()
 		return err
 	}
 
-	if err := out.Close(); err != nil {
-		return err
+	return out.Close()
+}
+
+// createFile creates the file at p for writing, replacing it, if it exists.
+//
+// The file is created read-only, with the read and execute permissions taken
+// from mode.
+func createFile(p string, mode fs.FileMode) (*os.File, error) {
+	// since hashed files are read-only, they cannot be truncated
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
 	}
 
-	return nil
+	return os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode&0o555)
 }
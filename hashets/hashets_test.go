@@ -1,12 +1,18 @@
 package hashets
 
 import (
+	"context"
+	"crypto/sha256"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -41,6 +47,118 @@ func TestHash(t *testing.T) {
 
 		assert.Equal(t, expect, actual)
 	})
+
+	t.Run("with extractors", func(t *testing.T) {
+		t.Parallel()
+
+		inFS := fstest.MapFS{
+			"logo.svg":  {Data: []byte("logo")},
+			"site.css":  {Data: []byte(`@import "base.css"; a { src: url(logo.svg) }`)},
+			"base.css":  {Data: []byte(`b { src: url(logo.svg) }`)},
+			"other.css": {Data: []byte(`c {}`)},
+		}
+
+		o := Options{Extractors: []ReferenceExtractor{CSSRewriter{}}}
+
+		before, err := Hash(inFS, o)
+		require.NoError(t, err)
+
+		unfolded, err := Hash(inFS, Options{})
+		require.NoError(t, err)
+
+		assert.Equal(t, unfolded["logo.svg"], before["logo.svg"])
+		assert.Equal(t, unfolded["other.css"], before["other.css"])
+		assert.NotEqual(t, unfolded["base.css"], before["base.css"])
+		assert.NotEqual(t, unfolded["site.css"], before["site.css"])
+
+		inFS["logo.svg"] = &fstest.MapFile{Data: []byte("new logo")}
+
+		after, err := Hash(inFS, o)
+		require.NoError(t, err)
+
+		assert.NotEqual(t, before["logo.svg"], after["logo.svg"])
+		assert.NotEqual(t, before["base.css"], after["base.css"])
+		assert.NotEqual(t, before["site.css"], after["site.css"])
+		assert.Equal(t, before["other.css"], after["other.css"])
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		t.Parallel()
+
+		actual, err := Hash(testdataIn, Options{NewHash: sha256.New, Concurrency: 4})
+		require.NoError(t, err)
+		assert.Equal(t, expectMap, actual)
+
+		inFS := fstest.MapFS{
+			"logo.svg": {Data: []byte("logo")},
+			"site.css": {Data: []byte(`@import "base.css"; a { src: url(logo.svg) }`)},
+			"base.css": {Data: []byte(`b { src: url(logo.svg) }`)},
+		}
+		for i := 0; i < 32; i++ {
+			inFS[fmt.Sprintf("%d.css", i)] = &fstest.MapFile{Data: []byte(fmt.Sprintf(`@import "site.css"; /* %d */`, i))}
+		}
+
+		o := Options{Extractors: []ReferenceExtractor{CSSRewriter{}}}
+
+		expect, err := Hash(inFS, o)
+		require.NoError(t, err)
+
+		o.Concurrency = 8
+
+		actual, err = Hash(inFS, o)
+		require.NoError(t, err)
+		assert.Equal(t, expect, actual)
+	})
+
+	t.Run("legacy hash", func(t *testing.T) {
+		t.Parallel()
+
+		actual, err := Hash(testdataIn, Options{Hash: sha256.New(), Concurrency: 4})
+		require.NoError(t, err)
+		assert.Equal(t, expectMap, actual)
+	})
+
+	t.Run("with collisions", func(t *testing.T) {
+		t.Parallel()
+
+		inFS := fstest.MapFS{
+			"a.txt": {Data: []byte("a")},
+			"b.txt": {Data: []byte("b")},
+		}
+
+		_, err := Hash(inFS, Options{NamingFunc: func(string, string) string { return "same.txt" }})
+
+		var cerr *CollisionError
+		require.ErrorAs(t, err, &cerr)
+		assert.Equal(t, &CollisionError{Path: "b.txt", HashedPath: "same.txt", Other: "a.txt"}, cerr)
+
+		aHashed, err := HashFile("a.txt", strings.NewReader("a"), Options{})
+		require.NoError(t, err)
+
+		inFS[aHashed] = &fstest.MapFile{Data: []byte("unrelated")}
+
+		for _, o := range []Options{{}, {Ignore: IgnorePrefix(aHashed)}} {
+			_, err = HashToDir(inFS, t.TempDir(), o)
+			require.ErrorAs(t, err, &cerr)
+			assert.Equal(t, &CollisionError{Path: "a.txt", HashedPath: aHashed, Other: aHashed}, cerr)
+		}
+	})
+
+	t.Run("with reference cycle", func(t *testing.T) {
+		t.Parallel()
+
+		inFS := fstest.MapFS{
+			"a.css": {Data: []byte(`@import "b.css";`)},
+			"b.css": {Data: []byte(`@import "c.css";`)},
+			"c.css": {Data: []byte(`@import "b.css";`)},
+		}
+
+		_, err := Hash(inFS, Options{Extractors: []ReferenceExtractor{CSSRewriter{}}})
+
+		var cerr *CycleError
+		require.ErrorAs(t, err, &cerr)
+		assert.Equal(t, []string{"b.css", "c.css", "b.css"}, cerr.Paths)
+	})
 }
 
 func TestHashFile(t *testing.T) {
@@ -68,6 +186,202 @@ func TestHashToDir(t *testing.T) {
 
 	assert.Equal(t, expectMap, actual)
 	dirsEqual(t, testdataExpect, os.DirFS(dir))
+
+	t.Run("with rewriters", func(t *testing.T) {
+		t.Parallel()
+
+		inFS := fstest.MapFS{
+			"fonts/x.woff2":  {Data: []byte("font")},
+			"css/site.css":   {Data: []byte(`@import "base.css"; a { src: url(../fonts/x.woff2) }`)},
+			"css/base.css":   {Data: []byte(`b { src: url("/fonts/x.woff2") }`)},
+			"css/broken.css": {Data: []byte(`c { src: url(missing.woff2) }`)},
+		}
+
+		dir := t.TempDir()
+
+		actual, err := HashToDir(inFS, dir, Options{Rewriters: []Rewriter{CSSRewriter{}}})
+		require.NoError(t, err)
+
+		font, err := HashFile("fonts/x.woff2", strings.NewReader("font"), Options{})
+		require.NoError(t, err)
+
+		baseData := `b { src: url("/` + font + `") }`
+		base, err := HashFile("css/base.css", strings.NewReader(baseData), Options{})
+		require.NoError(t, err)
+
+		siteData := `@import "` + path.Base(base) + `"; a { src: url(../` + font + `) }`
+		site, err := HashFile("css/site.css", strings.NewReader(siteData), Options{})
+		require.NoError(t, err)
+
+		broken, err := HashFile("css/broken.css", strings.NewReader(`c { src: url(missing.woff2) }`), Options{})
+		require.NoError(t, err)
+
+		assert.Equal(t, Map{
+			"fonts/x.woff2":  font,
+			"css/base.css":   base,
+			"css/site.css":   site,
+			"css/broken.css": broken,
+		}, actual)
+
+		dirsEqual(t, fstest.MapFS{
+			font:   {Data: []byte("font")},
+			base:   {Data: []byte(baseData)},
+			site:   {Data: []byte(siteData)},
+			broken: {Data: []byte(`c { src: url(missing.woff2) }`)},
+		}, os.DirFS(dir))
+	})
+
+	t.Run("idempotent", func(t *testing.T) {
+		t.Parallel()
+
+		files := map[string]string{
+			"a.txt":        "a",
+			"img/logo.svg": "logo",
+			"css/site.css": `a { src: url(../img/logo.svg) }`,
+			"css/base.css": `@import "site.css";`,
+		}
+
+		o := Options{
+			Rewriters:  []Rewriter{CSSRewriter{Prefix: "/static/"}},
+			Extractors: []ReferenceExtractor{extractorFunc(func(p string) bool { return p == "css/base.css" })},
+		}
+
+		t.Run("in place", func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			writeFiles(t, dir, files)
+
+			first, err := HashToDir(os.DirFS(dir), dir, o)
+			require.NoError(t, err)
+
+			// the history is never hashed
+			require.NoError(t, AppendHistory(dir, Manifest{}, 0))
+
+			firstFS := snapshotDir(t, dir)
+
+			second, err := HashToDir(os.DirFS(dir), dir, o)
+			require.NoError(t, err)
+
+			assert.Equal(t, first, second)
+			dirsEqual(t, firstFS, os.DirFS(dir))
+		})
+
+		t.Run("replaced", func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			writeFiles(t, dir, files)
+
+			first, err := HashToDir(os.DirFS(dir), dir, o)
+			require.NoError(t, err)
+
+			// base.css's hash includes site.css's hash, and cannot be
+			// recognized, once base.css is removed
+			for orig := range first {
+				if orig != "css/base.css" {
+					require.NoError(t, os.Remove(path.Join(dir, orig)))
+				}
+			}
+
+			firstFS := snapshotDir(t, dir)
+
+			second, err := HashToDir(os.DirFS(dir), dir, o)
+			require.NoError(t, err)
+
+			assert.Equal(t, first, second)
+			dirsEqual(t, firstFS, os.DirFS(dir))
+
+			outDir := t.TempDir()
+
+			third, err := HashToDir(os.DirFS(dir), outDir, o)
+			require.NoError(t, err)
+
+			assert.Equal(t, first, third)
+
+			delete(firstFS, "css/base.css")
+			dirsEqual(t, firstFS, os.DirFS(outDir))
+		})
+
+		t.Run("changed references", func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"img.svg":  "svg",
+				"site.css": `a { src: url(img.svg) }`,
+			})
+
+			o := Options{Extractors: []ReferenceExtractor{CSSRewriter{}}}
+
+			_, err := HashToDir(os.DirFS(dir), dir, o)
+			require.NoError(t, err)
+
+			// site.css's hashed files cannot be verified using their
+			// contents, since img.svg's hash is folded into them
+			writeFiles(t, dir, map[string]string{"img.svg": "svg2"})
+			_, err = HashToDir(os.DirFS(dir), dir, o)
+			require.NoError(t, err)
+
+			writeFiles(t, dir, map[string]string{"site.css": `b { src: url(img.svg) }`})
+			m, err := HashToDir(os.DirFS(dir), dir, o)
+			require.NoError(t, err)
+
+			assert.Len(t, m, 2)
+			assert.Contains(t, m, "img.svg")
+			assert.Contains(t, m, "site.css")
+
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			for _, e := range entries {
+				assert.NotRegexp(t, `_[0-9a-f]{64}_`, e.Name())
+			}
+		})
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		t.Parallel()
+
+		inFS := fstest.MapFS{
+			"fonts/x.woff2": {Data: []byte("font")},
+			"css/site.css":  {Data: []byte(`@import "base.css"; a { src: url(../fonts/x.woff2) }`)},
+			"css/base.css":  {Data: []byte(`b { src: url("/fonts/x.woff2") }`)},
+		}
+		for i := 0; i < 32; i++ {
+			inFS[fmt.Sprintf("css/%d.css", i)] = &fstest.MapFile{Data: []byte(fmt.Sprintf(`@import "site.css"; /* %d */`, i))}
+		}
+
+		o := Options{Rewriters: []Rewriter{CSSRewriter{}}}
+
+		expectDir := t.TempDir()
+		expect, err := HashToDir(inFS, expectDir, o)
+		require.NoError(t, err)
+
+		o.NewHash = sha256.New
+		o.Concurrency = 8
+
+		actualDir := t.TempDir()
+		actual, err := HashToDir(inFS, actualDir, o)
+		require.NoError(t, err)
+
+		assert.Equal(t, expect, actual)
+		dirsEqual(t, os.DirFS(expectDir), os.DirFS(actualDir))
+	})
+
+	t.Run("with reference cycle", func(t *testing.T) {
+		t.Parallel()
+
+		inFS := fstest.MapFS{
+			"a.css": {Data: []byte(`@import "b.css";`)},
+			"b.css": {Data: []byte(`@import "a.css";`)},
+		}
+
+		_, err := HashToDir(inFS, t.TempDir(), Options{Rewriters: []Rewriter{CSSRewriter{}}})
+
+		var cerr *CycleError
+		require.ErrorAs(t, err, &cerr)
+		assert.Equal(t, []string{"a.css", "b.css", "a.css"}, cerr.Paths)
+	})
 }
 
 func TestHashToTempDir(t *testing.T) {
@@ -138,3 +452,193 @@ func dirsEqual(t *testing.T, a, b fs.FS) {
 		return nil
 	})
 }
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+
+	actual, err := Merge(Map{"a.js": "a_1.js", "b.js": "b_2.js"}, nil, Map{"b.js": "b_2.js", "c.css": "c_3.css"})
+	require.NoError(t, err)
+	assert.Equal(t, Map{"a.js": "a_1.js", "b.js": "b_2.js", "c.css": "c_3.css"}, actual)
+
+	_, err = Merge(Map{"a.js": "a_1.js"}, Map{"a.js": "a_2.js"})
+	assert.Error(t, err)
+}
+
+func TestHashContext(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := HashContext(ctx, testdataIn, Options{})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// cancelFS is an [fs.FS] that calls cancel, when the file with the name
+// cancelAt is opened.
+type cancelFS struct {
+	fs.FS
+	cancelAt string
+	cancel   context.CancelFunc
+}
+
+func (f cancelFS) Open(name string) (fs.File, error) {
+	if name == f.cancelAt {
+		f.cancel()
+	}
+
+	return f.FS.Open(name)
+}
+
+func TestHashToDirContext(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(dir, "existing.txt"), []byte("existing"), 0o644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inFS := cancelFS{
+		FS: fstest.MapFS{
+			"a.txt":     {Data: []byte("a")},
+			"b/x.txt":   {Data: []byte("x")},
+			"c.txt":     {Data: []byte("c")},
+			"d/e/y.txt": {Data: []byte("y")},
+		},
+		cancelAt: "c.txt",
+		cancel:   cancel,
+	}
+
+	_, err := HashToDirContext(ctx, inFS, dir, Options{})
+	require.ErrorIs(t, err, context.Canceled)
+
+	dirsEqual(t, fstest.MapFS{"existing.txt": {Data: []byte("existing")}}, os.DirFS(dir))
+}
+
+// failFS is an [fs.FS] that fails to open the files and directories in fail.
+type failFS struct {
+	fs.FS
+	fail map[string]error
+}
+
+func (f failFS) Open(name string) (fs.File, error) {
+	if err := f.fail[name]; err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return f.FS.Open(name)
+}
+
+func TestHash_Errors(t *testing.T) {
+	t.Parallel()
+
+	errDir := errors.New("dir error")
+	errFile := errors.New("file error")
+
+	inFS := failFS{
+		FS: fstest.MapFS{
+			"a.txt":        {Data: []byte("a")},
+			"broken/b.txt": {Data: []byte("b")},
+			"c.css":        {Data: []byte("c")},
+			"d.css":        {Data: []byte(`@import "c.css";`)},
+			"e.css":        {Data: []byte(`e {}`)},
+		},
+		fail: map[string]error{"broken": errDir, "c.css": errFile},
+	}
+
+	t.Run("stop", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := Hash(inFS, Options{})
+		assert.ErrorIs(t, err, errDir)
+
+		_, err = HashToDir(inFS, t.TempDir(), Options{})
+		assert.ErrorIs(t, err, errDir)
+	})
+
+	t.Run("collect", func(t *testing.T) {
+		t.Parallel()
+
+		o := Options{Extractors: []ReferenceExtractor{CSSRewriter{}}, CollectErrors: true}
+
+		expect, err := Hash(fstest.MapFS{
+			"a.txt": {Data: []byte("a")},
+			"e.css": {Data: []byte(`e {}`)},
+		}, Options{})
+		require.NoError(t, err)
+
+		assertErrs := func(t *testing.T, err error) {
+			t.Helper()
+
+			var errs FileErrors
+			require.ErrorAs(t, err, &errs)
+			require.Len(t, errs, 3)
+
+			assert.Equal(t, "broken", errs[0].Path)
+			assert.ErrorIs(t, errs[0], errDir)
+			assert.Equal(t, "c.css", errs[1].Path)
+			assert.ErrorIs(t, errs[1], errFile)
+			assert.Equal(t, "d.css", errs[2].Path)
+
+			assert.ErrorIs(t, err, errDir)
+			assert.ErrorIs(t, err, errFile)
+		}
+
+		actual, err := Hash(inFS, o)
+		assertErrs(t, err)
+		assert.Equal(t, expect, actual)
+
+		dir := t.TempDir()
+
+		actual, err = HashToDir(inFS, dir, o)
+		assertErrs(t, err)
+		assert.Equal(t, expect, actual)
+
+		dirsEqual(t, fstest.MapFS{
+			expect["a.txt"]: {Data: []byte("a")},
+			expect["e.css"]: {Data: []byte(`e {}`)},
+			"broken":        {Mode: fs.ModeDir},
+		}, os.DirFS(dir))
+	})
+}
+
+// extractorFunc is a [ReferenceExtractor] for CSS files, that only matches
+// the files it reports true for.
+type extractorFunc func(p string) bool
+
+func (f extractorFunc) Match(p string) bool { return f(p) }
+
+func (f extractorFunc) References(p string, data []byte) []string {
+	return CSSRewriter{}.References(p, data)
+}
+
+// writeFiles writes the passed files to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for p, data := range files {
+		p = filepath.Join(dir, p)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(data), 0o644))
+	}
+}
+
+// snapshotDir returns a copy of the files in dir.
+func snapshotDir(t *testing.T, dir string) fstest.MapFS {
+	t.Helper()
+
+	snapshot := make(fstest.MapFS)
+	err := fs.WalkDir(os.DirFS(dir), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, p))
+		snapshot[p] = &fstest.MapFile{Data: data}
+		return err
+	})
+	require.NoError(t, err)
+
+	return snapshot
+}
@@ -0,0 +1,194 @@
+package hashets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// metaDir is the directory, relative to the root of a hashed directory,
+	// that hashets stores its own files in.
+	//
+	// It is never hashed.
+	metaDir = ".hashets"
+	// HistoryDir is the directory, relative to the root of a hashed
+	// directory, that [AppendHistory] stores the manifests of the
+	// generations of the directory in.
+	//
+	// Since it starts with a ".", //go:embed only includes it if the
+	// pattern is prefixed with "all:", e.g. "//go:embed all:static".
+	HistoryDir = metaDir + "/history"
+)
+
+// History holds the manifests of the previous generations of a hashed
+// directory, most recent first.
+//
+// It can be used to continue serving the hashed files of previous
+// generations, e.g. during rolling deployments, where pages rendered by
+// outdated instances still reference them.
+// See [FSWrapper.WithHistory].
+type History []Manifest
+
+func (h History) maps() []Map {
+	maps := make([]Map, len(h))
+	for i, man := range h {
+		maps[i] = man.Map()
+	}
+
+	return maps
+}
+
+// AppendHistory adds man as the most recent generation to the history of the
+// hashed directory at dirPath, which is stored in [HistoryDir].
+//
+// If man maps the same files to the same hashed files as the most recent
+// generation, no new generation is added.
+//
+// If keep is greater than 0, only the keep most recent generations are kept,
+// and older ones are removed.
+func AppendHistory(dirPath string, man Manifest, keep int) error {
+	histPath := filepath.Join(dirPath, filepath.FromSlash(HistoryDir))
+	if err := os.MkdirAll(histPath, 0o755); err != nil {
+		return err
+	}
+
+	gens, err := historyGenerations(os.DirFS(dirPath))
+	if err != nil {
+		return err
+	}
+
+	if len(gens) > 0 {
+		latest, err := readGeneration(os.DirFS(dirPath), gens[len(gens)-1])
+		if err != nil {
+			return err
+		}
+
+		if mapsEqual(latest.Map(), man.Map()) {
+			return nil
+		}
+	}
+
+	var gen uint64 = 1
+	if len(gens) > 0 {
+		gen = gens[len(gens)-1] + 1
+	}
+
+	var buf bytes.Buffer
+	if err := man.WriteJSON(&buf, FormatHashets); err != nil {
+		return err
+	}
+
+	genPath := filepath.Join(histPath, strconv.FormatUint(gen, 10)+".json")
+	if err := os.WriteFile(genPath, buf.Bytes(), 0o644); err != nil { //nolint:gosec
+		return err
+	}
+
+	gens = append(gens, gen)
+	if keep <= 0 || len(gens) <= keep {
+		return nil
+	}
+
+	for _, gen := range gens[:len(gens)-keep] {
+		err := os.Remove(filepath.Join(histPath, strconv.FormatUint(gen, 10)+".json"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadHistory reads the n most recent generations of the history stored in
+// the [HistoryDir] of filesys.
+// If n is 0 or less, all generations are read.
+//
+// If there is no history, ReadHistory returns nil.
+// If filesys is an [embed.FS], make sure to embed the directory using the
+// "all:" prefix, as the history is otherwise not embedded, see [HistoryDir].
+func ReadHistory(filesys fs.FS, n int) (History, error) {
+	gens, err := historyGenerations(filesys)
+	if err != nil {
+		return nil, err
+	}
+
+	if n > 0 && len(gens) > n {
+		gens = gens[len(gens)-n:]
+	}
+
+	var h History
+	for i := len(gens) - 1; i >= 0; i-- {
+		man, err := readGeneration(filesys, gens[i])
+		if err != nil {
+			return nil, err
+		}
+
+		h = append(h, man)
+	}
+
+	return h, nil
+}
+
+// historyGenerations returns the generations stored in the history of
+// filesys, in ascending order.
+func historyGenerations(filesys fs.FS) ([]uint64, error) {
+	entries, err := fs.ReadDir(filesys, HistoryDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	gens := make([]uint64, 0, len(entries))
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+
+		if gen, err := strconv.ParseUint(name, 10, 64); err == nil {
+			gens = append(gens, gen)
+		}
+	}
+
+	sort.Slice(gens, func(i, j int) bool { return gens[i] < gens[j] })
+	return gens, nil
+}
+
+func readGeneration(filesys fs.FS, gen uint64) (Manifest, error) {
+	f, err := filesys.Open(path.Join(HistoryDir, strconv.FormatUint(gen, 10)+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	man, err := ReadManifest(f, FormatHashets)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("history: generation %d: %w", gen, err)
+	}
+
+	return man, f.Close()
+}
+
+func mapsEqual(a, b Map) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+
+	return true
+}
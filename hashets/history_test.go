@@ -0,0 +1,91 @@
+package hashets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+
+	gen1 := Manifest{"a.js": {Path: "a.js", HashedPath: "a_1.js"}}
+	gen2 := Manifest{"a.js": {Path: "a.js", HashedPath: "a_2.js"}}
+	gen3 := Manifest{"a.js": {Path: "a.js", HashedPath: "a_3.js"}, "b.js": {Path: "b.js", HashedPath: "b_3.js"}}
+
+	dir := t.TempDir()
+
+	h, err := ReadHistory(os.DirFS(dir), 0)
+	require.NoError(t, err)
+	assert.Nil(t, h)
+
+	require.NoError(t, AppendHistory(dir, gen1, 2))
+	require.NoError(t, AppendHistory(dir, gen1, 2))
+	require.NoError(t, AppendHistory(dir, gen2, 2))
+
+	h, err = ReadHistory(os.DirFS(dir), 0)
+	require.NoError(t, err)
+	assert.Equal(t, History{gen2, gen1}, h)
+
+	require.NoError(t, AppendHistory(dir, gen3, 2))
+
+	h, err = ReadHistory(os.DirFS(dir), 0)
+	require.NoError(t, err)
+	assert.Equal(t, History{gen3, gen2}, h)
+
+	h, err = ReadHistory(os.DirFS(dir), 1)
+	require.NoError(t, err)
+	assert.Equal(t, History{gen3}, h)
+
+	entries, err := os.ReadDir(filepath.Join(dir, HistoryDir))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestFSWrapper_WithHistory(t *testing.T) {
+	t.Parallel()
+
+	inFS := fstest.MapFS{
+		"a.js":   {Data: []byte("a3")},
+		"a_1.js": {Data: []byte("a1")},
+	}
+
+	fsw, m, err := WrapFS(inFS, Options{Ignore: IgnorePrefix("a_")})
+	require.NoError(t, err)
+
+	fsw = fsw.WithHistory(History{
+		{"a.js": {Path: "a.js", HashedPath: "a_2.js"}},
+		{"a.js": {Path: "a.js", HashedPath: "a_1.js"}},
+	})
+
+	assert.False(t, fsw.IsPrevious(m["a.js"]))
+	assert.True(t, fsw.IsPrevious("a_2.js"))
+
+	data, err := fsw.ReadFile("a_2.js")
+	require.NoError(t, err)
+	assert.Equal(t, "a3", string(data))
+
+	data, err = fsw.ReadFile("a_1.js")
+	require.NoError(t, err)
+	assert.Equal(t, "a1", string(data))
+
+	h := NewHandler(fsw, HandlerOptions{})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a_2.js", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "a3", rec.Body.String())
+	assert.Equal(t, NoCacheControl, rec.Header().Get("Cache-Control"))
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a_1.js", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "a1", rec.Body.String())
+	assert.Equal(t, ImmutableCacheControl, rec.Header().Get("Cache-Control"))
+}
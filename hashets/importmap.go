@@ -0,0 +1,113 @@
+package hashets
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ImportMap is an import map, as used by <script type="importmap">.
+//
+// It can be used as an alternative to rewriting the module specifiers of
+// JavaScript modules using a [JSRewriter]:
+// Instead of importing "/static/util_1234.js", modules import
+// "/static/util.js", which the browser then resolves to "/static/util_1234.js"
+// using the import map.
+//
+// Note that import maps only apply to module specifiers, and not to URLs
+// used in other places, such as new URL("./x", import.meta.url).
+type ImportMap struct {
+	// Imports maps module specifiers to the URLs of the modules.
+	Imports map[string]string `json:"imports"`
+	// Scopes maps URL prefixes to module specifier maps, that are only
+	// used for modules whose URL starts with the prefix.
+	Scopes map[string]map[string]string `json:"scopes,omitempty"`
+}
+
+// ImportMapOptions provides configuration options for [NewImportMap].
+type ImportMapOptions struct {
+	// Prefix is the URL path under which the root of the hashed [fs.FS] is
+	// served, e.g. "/static/".
+	//
+	// Defaults to "/".
+	Prefix string
+
+	// Match reports whether the file with the passed path should be
+	// included in the import map.
+	//
+	// Defaults to matching files with the extensions ".js" and ".mjs".
+	Match func(path string) bool
+}
+
+func (o *ImportMapOptions) setDefaults() {
+	o.Prefix = normalizePrefix(o.Prefix)
+
+	if o.Match == nil {
+		o.Match = JSRewriter{}.Match
+	}
+}
+
+// NewImportMap creates a new [ImportMap], that maps the URL of each file in m,
+// to the URL of its hashed equivalent.
+//
+// For example, if m maps "util.js" to "util_1234.js" and the prefix is
+// "/static/", the import map maps "/static/util.js" to
+// "/static/util_1234.js".
+func NewImportMap(m Map, o ImportMapOptions) ImportMap {
+	return ImportMap{Imports: importMapImports(m, o)}
+}
+
+// AddScope adds the mappings for the files in m as the scope with the passed
+// URL prefix.
+//
+// The mappings of a scope take precedence over [ImportMap.Imports], for
+// modules whose URL starts with scope.
+func (im *ImportMap) AddScope(scope string, m Map, o ImportMapOptions) {
+	if im.Scopes == nil {
+		im.Scopes = make(map[string]map[string]string)
+	}
+
+	im.Scopes[scope] = importMapImports(m, o)
+}
+
+// HTML returns the import map as <script type="importmap"> element.
+func (im ImportMap) HTML() (template.HTML, error) {
+	data, err := json.Marshal(im)
+	if err != nil {
+		return "", err
+	}
+
+	// json.Marshal escapes <, >, and &, so data cannot end the script
+	// element prematurely
+	return template.HTML(`<script type="importmap">` + string(data) + `</script>`), nil //nolint:gosec
+}
+
+func importMapImports(m Map, o ImportMapOptions) map[string]string {
+	o.setDefaults()
+
+	imports := make(map[string]string, len(m))
+	for origPath, hashedPath := range m {
+		if o.Match(origPath) {
+			imports[importMapURL(o.Prefix, origPath)] = importMapURL(o.Prefix, hashedPath)
+		}
+	}
+
+	return imports
+}
+
+// importMapURL returns the escaped URL path of the file at p.
+//
+// If p has a query, as generated by [QueryNaming], only the path is escaped,
+// and the query is appended as is.
+func importMapURL(prefix, p string) string {
+	p, query, hasQuery := strings.Cut(p, "?")
+
+	u := url.URL{Path: prefix + strings.TrimPrefix(path.Clean("/"+p), "/")}
+	if hasQuery {
+		return u.EscapedPath() + "?" + query
+	}
+
+	return u.EscapedPath()
+}
@@ -0,0 +1,41 @@
+package hashets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewImportMap(t *testing.T) {
+	t.Parallel()
+
+	m := Map{
+		"util.js":       "util_1234.js",
+		"lib/a b.mjs":   "lib/a b_1234.mjs",
+		"style.css":     "style_1234.css",
+		"legacy/x.js":   "legacy/x_1234.js",
+		"legacy/x.json": "legacy/x_1234.json",
+		"q u.js":        "q u.js?v=1234",
+	}
+
+	im := NewImportMap(m, ImportMapOptions{Prefix: "static"})
+	assert.Equal(t, ImportMap{
+		Imports: map[string]string{
+			"/static/util.js":       "/static/util_1234.js",
+			"/static/lib/a%20b.mjs": "/static/lib/a%20b_1234.mjs",
+			"/static/legacy/x.js":   "/static/legacy/x_1234.js",
+			"/static/q%20u.js":      "/static/q%20u.js?v=1234",
+		},
+	}, im)
+
+	im.AddScope("/static/legacy/", Map{"util.js": "legacy/util_1234.js"}, ImportMapOptions{Prefix: "/static/"})
+	assert.Equal(t, map[string]map[string]string{
+		"/static/legacy/": {"/static/util.js": "/static/legacy/util_1234.js"},
+	}, im.Scopes)
+
+	html, err := NewImportMap(Map{"<x>.js": "<x>_1234.js"}, ImportMapOptions{}).HTML()
+	require.NoError(t, err)
+	assert.Equal(t,
+		`<script type="importmap">{"imports":{"/%3Cx%3E.js":"/%3Cx%3E_1234.js"}}</script>`, string(html))
+}
@@ -0,0 +1,264 @@
+package hashets
+
+import (
+	"bytes"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// JSRewriter is a [Rewriter] for JavaScript modules.
+//
+// It rewrites the module specifiers of static imports and exports, e.g.
+// import x from "./x.js", of dynamic imports, e.g. import("./x.js"), and the
+// URLs of new URL("./x", import.meta.url) expressions.
+//
+// Bare module specifiers, i.e. specifiers that start neither with "/", "./",
+// nor "../", such as "lodash", are left as is.
+type JSRewriter struct {
+	// Prefix is the URL path under which the root of the hashed [fs.FS] is
+	// served, e.g. "/static/".
+	// It is used to resolve and rewrite absolute URLs.
+	//
+	// If Prefix is empty, absolute URLs are resolved relative to the root of
+	// the [fs.FS].
+	Prefix string
+}
+
+var _ Rewriter = JSRewriter{}
+
+// Match reports whether p has the extension ".js" or ".mjs".
+func (rw JSRewriter) Match(p string) bool {
+	ext := path.Ext(p)
+	return strings.EqualFold(ext, ".js") || strings.EqualFold(ext, ".mjs")
+}
+
+func (rw JSRewriter) References(p string, data []byte) []string {
+	return references(p, rw.Prefix, data, scanJS(data))
+}
+
+func (rw JSRewriter) Rewrite(p string, data []byte, m Map) []byte {
+	return rewrite(p, rw.Prefix, data, scanJS(data), m)
+}
+
+// jsLiteral is the token used by jsScanner for literals.
+const jsLiteral = "\x00"
+
+var (
+	// jsDynamicImportEnd matches what may follow the specifier of a dynamic
+	// import.
+	jsDynamicImportEnd = regexp.MustCompile(`^\s*[),]`)
+	// jsImportMetaURL matches what must follow the URL of a
+	// new URL(url, import.meta.url) expression.
+	jsImportMetaURL = regexp.MustCompile(`^\s*,\s*import\s*\.\s*meta\s*\.\s*url\s*\)`)
+)
+
+// scanJS returns the spans of all module specifiers and URLs in the passed
+// JavaScript module.
+func scanJS(data []byte) []span {
+	s := jsScanner{data: data}
+	s.scan(false)
+	return s.spans
+}
+
+type jsScanner struct {
+	data  []byte
+	i     int
+	spans []span
+
+	// tokens are the last three tokens, with the most recent token last.
+	// Identifiers and punctuators are stored as is, all other tokens, such
+	// as strings or numbers, as jsLiteral.
+	// If there are less than three tokens, the remaining ones are empty.
+	tokens [3]string
+	// inDecl is true while inside an import or export declaration.
+	inDecl bool
+}
+
+// scan scans tokens until the end of the data, or, if inTemplate is true,
+// until the closing brace of a template literal substitution.
+func (s *jsScanner) scan(inTemplate bool) {
+	var depth int
+
+	for s.i < len(s.data) {
+		b := s.data[s.i]
+
+		switch {
+		case isSpace(b):
+			s.i++
+		case bytes.HasPrefix(s.data[s.i:], []byte("//")):
+			end := bytes.IndexByte(s.data[s.i:], '\n')
+			if end < 0 {
+				s.i = len(s.data)
+				return
+			}
+
+			s.i += end
+		case bytes.HasPrefix(s.data[s.i:], []byte("/*")):
+			end := bytes.Index(s.data[s.i+2:], []byte("*/"))
+			if end < 0 {
+				s.i = len(s.data)
+				return
+			}
+
+			s.i += 2 + end + 2
+		case b == '"' || b == '\'':
+			s.string()
+		case b == '`':
+			s.template()
+		case b == '/' && s.regexpAllowed():
+			s.regexp()
+		case isJSIdentByte(b):
+			start := s.i
+			for s.i < len(s.data) && (isJSIdentByte(s.data[s.i]) || '0' <= s.data[s.i] && s.data[s.i] <= '9') {
+				s.i++
+			}
+
+			ident := string(s.data[start:s.i])
+			if ident == "import" || ident == "export" {
+				s.inDecl = true
+			}
+
+			s.push(ident)
+		case '0' <= b && b <= '9':
+			for s.i < len(s.data) && (isJSIdentByte(s.data[s.i]) || '0' <= s.data[s.i] && s.data[s.i] <= '9' || s.data[s.i] == '.') {
+				s.i++
+			}
+
+			s.push(jsLiteral)
+		default:
+			s.i++
+
+			switch b {
+			case '{':
+				depth++
+			case '}':
+				if inTemplate && depth == 0 {
+					return
+				}
+
+				depth--
+			case ';':
+				s.inDecl = false
+			}
+
+			s.push(string(b))
+		}
+	}
+}
+
+// string scans a string literal and records its span, if it is a module
+// specifier or URL.
+func (s *jsScanner) string() {
+	str, end, ok := scanString(s.data, s.i)
+	s.i = end
+	if !ok {
+		s.push(jsLiteral)
+		return
+	}
+
+	spec := string(s.data[str.start:str.end])
+	rest := s.data[end:]
+
+	switch {
+	case s.tokens[2] == "import" && s.tokens[1] != ".":
+		// import "./x.js"
+		s.addSpecifier(str, spec)
+	case s.tokens[2] == "from" && s.inDecl:
+		// import x from "./x.js", export * from "./x.js"
+		s.addSpecifier(str, spec)
+		s.inDecl = false
+	case s.tokens[2] == "(" && s.tokens[1] == "import" && jsDynamicImportEnd.Match(rest):
+		// import("./x.js")
+		s.addSpecifier(str, spec)
+	case s.tokens[2] == "(" && s.tokens[1] == "URL" && s.tokens[0] == "new" && jsImportMetaURL.Match(rest):
+		// new URL("./x.js", import.meta.url)
+		s.spans = append(s.spans, str)
+	}
+
+	s.push(jsLiteral)
+}
+
+// addSpecifier records the span of the module specifier spec, if it is not a
+// bare specifier.
+func (s *jsScanner) addSpecifier(str span, spec string) {
+	if strings.HasPrefix(spec, "/") || strings.HasPrefix(spec, "./") || strings.HasPrefix(spec, "../") {
+		s.spans = append(s.spans, str)
+	}
+}
+
+// template scans a template literal, including its substitutions.
+func (s *jsScanner) template() {
+	for s.i++; s.i < len(s.data); s.i++ {
+		switch s.data[s.i] {
+		case '\\':
+			s.i++
+		case '`':
+			s.i++
+			s.push(jsLiteral)
+			return
+		case '$':
+			if s.i+1 < len(s.data) && s.data[s.i+1] == '{' {
+				s.i += 2
+				s.push("{")
+				s.scan(true)
+				// compensate for the loop's increment
+				s.i--
+			}
+		}
+	}
+}
+
+// regexp skips a regular expression literal.
+func (s *jsScanner) regexp() {
+	var inClass bool
+
+	for s.i++; s.i < len(s.data); s.i++ {
+		switch s.data[s.i] {
+		case '\\':
+			s.i++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '\n':
+			// not a regular expression after all
+			s.push("/")
+			return
+		case '/':
+			if !inClass {
+				s.i++
+				for s.i < len(s.data) && isJSIdentByte(s.data[s.i]) {
+					s.i++
+				}
+
+				s.push(jsLiteral)
+				return
+			}
+		}
+	}
+}
+
+// regexpAllowed reports whether a slash at the current position starts a
+// regular expression literal, as opposed to being a division operator.
+func (s *jsScanner) regexpAllowed() bool {
+	switch prev := s.tokens[2]; prev {
+	case "":
+		return true
+	case jsLiteral, ")", "]", "}":
+		return false
+	case "return", "typeof", "instanceof", "in", "of", "new", "delete", "void",
+		"throw", "case", "do", "else", "yield", "await":
+		return true
+	default:
+		return !isJSIdentByte(prev[0])
+	}
+}
+
+func (s *jsScanner) push(tok string) {
+	s.tokens[0], s.tokens[1], s.tokens[2] = s.tokens[1], s.tokens[2], tok
+}
+
+func isJSIdentByte(b byte) bool {
+	return b == '_' || b == '$' || b >= 0x80 || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
@@ -0,0 +1,99 @@
+package hashets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSRewriter(t *testing.T) {
+	t.Parallel()
+
+	m := Map{
+		"js/util.js":    "js/util_1234.js",
+		"js/chunk.mjs":  "js/chunk_1234.mjs",
+		"img/logo.svg":  "img/logo_1234.svg",
+		"js/worker.js":  "js/worker_1234.js",
+		"js/lib/a.js":   "js/lib/a_1234.js",
+		"js/lib/b.js":   "js/lib/b_1234.js",
+		"js/lib/c.js":   "js/lib/c_1234.js",
+		"js/lodash":     "js/lodash_1234",
+		"js/regexp.js":  "js/regexp_1234.js",
+		"js/comment.js": "js/comment_1234.js",
+	}
+
+	cases := []struct {
+		name             string
+		prefix           string
+		in               string
+		expect           string
+		expectReferences []string
+	}{
+		{
+			name: "static",
+			in: `import util from "./util.js";
+import { a } from './lib/a.js'
+import "./lib/b.js";
+export * from "./lib/c.js";
+import _ from "lodash";`,
+			expect: `import util from "./util_1234.js";
+import { a } from './lib/a_1234.js'
+import "./lib/b_1234.js";
+export * from "./lib/c_1234.js";
+import _ from "lodash";`,
+			expectReferences: []string{"js/util.js", "js/lib/a.js", "js/lib/b.js", "js/lib/c.js"},
+		},
+		{
+			name:             "dynamic",
+			in:               `const c = await import("./chunk.mjs"); import("./lib/" + name);`,
+			expect:           `const c = await import("./chunk_1234.mjs"); import("./lib/" + name);`,
+			expectReferences: []string{"js/chunk.mjs"},
+		},
+		{
+			name:             "new URL",
+			prefix:           "/static/",
+			in:               `new URL("../img/logo.svg", import.meta.url); new Worker(new URL('/static/js/worker.js', import.meta.url))`,
+			expect:           `new URL("../img/logo_1234.svg", import.meta.url); new Worker(new URL('/static/js/worker_1234.js', import.meta.url))`,
+			expectReferences: []string{"img/logo.svg", "js/worker.js"},
+		},
+		{
+			name: "ignored",
+			in: `// import "./comment.js"
+/* import "./comment.js" */
+const s = 'import "./util.js"', t = ` + "`${x} from \"./util.js\" ${`nested`}`" + `;
+const r = /import "\.\/regexp.js"/g, d = a / 2 / b;
+const o = { from: "./util.js" };
+new URL("./util.js");`,
+			expect: `// import "./comment.js"
+/* import "./comment.js" */
+const s = 'import "./util.js"', t = ` + "`${x} from \"./util.js\" ${`nested`}`" + `;
+const r = /import "\.\/regexp.js"/g, d = a / 2 / b;
+const o = { from: "./util.js" };
+new URL("./util.js");`,
+		},
+		{
+			name:             "template substitution",
+			in:               "const x = `${await import(\"./util.js\")}`; import \"./chunk.mjs\";",
+			expect:           "const x = `${await import(\"./util_1234.js\")}`; import \"./chunk_1234.mjs\";",
+			expectReferences: []string{"js/util.js", "js/chunk.mjs"},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			rw := JSRewriter{Prefix: c.prefix}
+
+			refs := rw.References("js/main.js", []byte(c.in))
+			if c.expectReferences == nil {
+				assert.Empty(t, refs)
+			} else {
+				assert.Equal(t, c.expectReferences, refs)
+			}
+
+			assert.Equal(t, c.expect, string(rw.Rewrite("js/main.js", []byte(c.in), m)))
+		})
+	}
+}
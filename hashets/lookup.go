@@ -0,0 +1,163 @@
+package hashets
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Lookup returns the hashed file path for the given file path, and whether
+// the file path is known.
+//
+// Leading "/" and "./" are ignored, so that "/foo.txt", "./foo.txt", and
+// "foo.txt" all look up "foo.txt".
+// If name starts with a "/", so does the returned path.
+//
+// If m is nil, the original file path is returned, see [Map.Get].
+func (m Map) Lookup(name string) (string, bool) {
+	if m == nil {
+		return name, true
+	}
+
+	key, abs := lookupKey(name)
+
+	hashed, ok := m[key]
+	if !ok {
+		return "", false
+	}
+
+	if abs {
+		return "/" + hashed, true
+	}
+
+	return hashed, true
+}
+
+// MustGet works like [Map.Get], but panics if the file path is unknown.
+//
+// It is intended for templates and initialization code, where an unknown
+// file path is a programming error, e.g. a typo, that should not silently
+// result in an empty path.
+func (m Map) MustGet(name string) string {
+	hashed, ok := m.Lookup(name)
+	if !ok {
+		panic("hashets: Map.MustGet: " + unknownFileMessage(m, name))
+	}
+
+	return hashed
+}
+
+// Fallback determines what a [Resolver] returns for file paths that are not
+// part of its [Map].
+//
+// It is called with the requested file path, and returns the path to use
+// instead, or an error.
+type Fallback func(name string) (string, error)
+
+// FallbackEmpty is a [Fallback] that returns an empty string, like
+// [Map.Get].
+func FallbackEmpty(string) (string, error) { return "", nil }
+
+// FallbackOriginal is a [Fallback] that returns the original file path.
+func FallbackOriginal(name string) (string, error) { return name, nil }
+
+// FallbackError is a [Fallback] that returns an [*fs.PathError] wrapping
+// [fs.ErrNotExist].
+//
+// When used in a template function, the error aborts the execution of the
+// template.
+func FallbackError(name string) (string, error) {
+	return "", &fs.PathError{Op: "lookup", Path: name, Err: fs.ErrNotExist}
+}
+
+// FallbackLog returns a [Fallback] that logs the unknown file path using
+// logf, and returns the original file path.
+//
+// If logf is nil, [log.Printf] is used.
+func FallbackLog(logf func(format string, args ...any)) Fallback {
+	if logf == nil {
+		logf = log.Printf
+	}
+
+	return func(name string) (string, error) {
+		logf("hashets: %s", unknownFileMessage(nil, name))
+		return name, nil
+	}
+}
+
+// Resolver resolves file paths to hashed file paths using a [Map], and
+// handles unknown file paths using a [Fallback].
+//
+// Its Get method can be used as template function:
+//
+//	r := hashets.Resolver{Map: FileNames, Fallback: hashets.FallbackError}
+//	tmpl.Funcs(template.FuncMap{"asset": r.Get})
+type Resolver struct {
+	// Map is the map used to look up file paths, see [Map.Lookup].
+	//
+	// If Map is nil, all file paths are returned as is.
+	Map Map
+	// Fallback is called for file paths that are not part of Map.
+	//
+	// Defaults to [FallbackEmpty].
+	Fallback Fallback
+}
+
+// Get returns the hashed file path for the given file path, or the result
+// of r.Fallback, if the file path is unknown.
+func (r Resolver) Get(name string) (string, error) {
+	if hashed, ok := r.Map.Lookup(name); ok {
+		return hashed, nil
+	}
+
+	if r.Fallback == nil {
+		return FallbackEmpty(name)
+	}
+
+	return r.Fallback(name)
+}
+
+// lookupKey returns the key of m that is looked up for name, and whether name
+// is absolute, i.e. starts with a "/".
+func lookupKey(name string) (key string, abs bool) {
+	abs = strings.HasPrefix(name, "/")
+
+	for {
+		trimmed := strings.TrimPrefix(strings.TrimLeft(name, "/"), "./")
+		if trimmed == name {
+			return name, abs
+		}
+
+		name = trimmed
+	}
+}
+
+// unknownFileMessage returns a message describing that name is not part of
+// m, with hints on what may have been meant instead.
+func unknownFileMessage(m Map, name string) string {
+	msg := fmt.Sprintf("unknown file %q", name)
+
+	key, _ := lookupKey(name)
+	for orig, hashed := range m {
+		if hashed == key {
+			return msg + fmt.Sprintf(" (it is the hashed path of %q)", orig)
+		}
+	}
+
+	var similar []string
+	for orig := range m {
+		if path.Base(orig) == path.Base(key) || strings.EqualFold(orig, key) {
+			similar = append(similar, orig)
+		}
+	}
+
+	if len(similar) == 0 {
+		return msg
+	}
+
+	sort.Strings(similar)
+	return msg + fmt.Sprintf(" (did you mean %q?)", similar[0])
+}
@@ -0,0 +1,137 @@
+package hashets
+
+import (
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMap_Lookup(t *testing.T) {
+	t.Parallel()
+
+	m := Map{"css/site.css": "css/site_1234.css"}
+
+	testCases := []struct {
+		name     string
+		m        Map
+		lookup   string
+		expect   string
+		expectOK bool
+	}{
+		{name: "plain", m: m, lookup: "css/site.css", expect: "css/site_1234.css", expectOK: true},
+		{name: "absolute", m: m, lookup: "/css/site.css", expect: "/css/site_1234.css", expectOK: true},
+		{name: "dot slash", m: m, lookup: "./css/site.css", expect: "css/site_1234.css", expectOK: true},
+		{name: "repeated", m: m, lookup: "//./css/site.css", expect: "/css/site_1234.css", expectOK: true},
+		{name: "unknown", m: m, lookup: "css/sight.css", expect: "", expectOK: false},
+		{name: "nil", m: nil, lookup: "/css/site.css", expect: "/css/site.css", expectOK: true},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual, ok := c.m.Lookup(c.lookup)
+			assert.Equal(t, c.expect, actual)
+			assert.Equal(t, c.expectOK, ok)
+
+			assert.Equal(t, c.expect, c.m.Get(c.lookup))
+		})
+	}
+}
+
+func TestMap_MustGet(t *testing.T) {
+	t.Parallel()
+
+	m := Map{"css/site.css": "css/site_1234.css"}
+
+	assert.Equal(t, "/css/site_1234.css", m.MustGet("/css/site.css"))
+
+	testCases := []struct {
+		name   string
+		lookup string
+		expect string
+	}{
+		{
+			name:   "unknown",
+			lookup: "foo.css",
+			expect: `hashets: Map.MustGet: unknown file "foo.css"`,
+		},
+		{
+			name:   "similar",
+			lookup: "site.css",
+			expect: `hashets: Map.MustGet: unknown file "site.css" (did you mean "css/site.css"?)`,
+		},
+		{
+			name:   "hashed",
+			lookup: "/css/site_1234.css",
+			expect: `hashets: Map.MustGet: unknown file "/css/site_1234.css" (it is the hashed path of "css/site.css")`,
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			assert.PanicsWithValue(t, c.expect, func() { m.MustGet(c.lookup) })
+		})
+	}
+}
+
+func TestResolver(t *testing.T) {
+	t.Parallel()
+
+	m := Map{"foo.txt": "foo_1234.txt"}
+
+	testCases := []struct {
+		name     string
+		fallback Fallback
+		expect   string
+		// expectErr is the expected error, or nil.
+		expectErr error
+	}{
+		{name: "default", fallback: nil, expect: ""},
+		{name: "empty", fallback: FallbackEmpty, expect: ""},
+		{name: "original", fallback: FallbackOriginal, expect: "/bar.txt"},
+		{name: "error", fallback: FallbackError, expectErr: fs.ErrNotExist},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := Resolver{Map: m, Fallback: c.fallback}
+
+			actual, err := r.Get("/foo.txt")
+			require.NoError(t, err)
+			assert.Equal(t, "/foo_1234.txt", actual)
+
+			actual, err = r.Get("/bar.txt")
+			if c.expectErr != nil {
+				assert.ErrorIs(t, err, c.expectErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, c.expect, actual)
+		})
+	}
+
+	t.Run("log", func(t *testing.T) {
+		t.Parallel()
+
+		var logged []string
+		logf := func(format string, args ...any) { logged = append(logged, fmt.Sprintf(format, args...)) }
+
+		r := Resolver{Map: m, Fallback: FallbackLog(logf)}
+
+		actual, err := r.Get("/bar.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "/bar.txt", actual)
+		assert.Equal(t, []string{`hashets: unknown file "/bar.txt"`}, logged)
+	})
+}
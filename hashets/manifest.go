@@ -0,0 +1,166 @@
+package hashets
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+)
+
+// Entry holds information about a hashed file.
+type Entry struct {
+	// Path is the original path of the file.
+	Path string
+	// HashedPath is the path of the hashed file, i.e. Path with the file
+	// name replaced by the hashed file name.
+	HashedPath string
+
+	// Digest is the raw hash of the file, as computed by [Options.NewHash].
+	//
+	// If the file references other files, Digest is the hash of the file's
+	// contents folded with the hashes of the referenced files.
+	Digest []byte
+	// Hash is the textual representation of Digest, as returned by
+	// [Options.HashToText].
+	Hash string
+	// Integrity is the Subresource Integrity string of the file, computed
+	// using [Options.SRI].
+	//
+	// It is only computed, if Options.SRI is set.
+	Integrity string
+
+	// Size is the size of the hashed file in bytes.
+	Size int64
+	// ContentType is the MIME type of the file.
+	//
+	// It is detected using the file's extension, or, if the extension is
+	// unknown, using [http.DetectContentType].
+	ContentType string
+	// Mode is the file mode of the original file.
+	Mode fs.FileMode
+
+	// Variants are the precompressed variants of the hashed file, written
+	// if [Options.Compression] is set.
+	Variants []Variant
+
+	// Unhashed reports whether the file was ignored, and the entry was only
+	// added because [Options.PassThrough] is set.
+	//
+	// HashedPath of such an entry equals Path, and only Size, ContentType,
+	// and Mode are set.
+	Unhashed bool
+}
+
+// Manifest represents a map of original file paths to the [Entry] of the
+// file.
+//
+// In contrast to a [Map], it contains all information about a hashed file
+// that is available during hashing.
+type Manifest map[string]Entry
+
+// Map returns the [Map] of the original file paths to the hashed file paths.
+//
+// If man is nil, Map returns nil.
+func (man Manifest) Map() Map {
+	if man == nil {
+		return nil
+	}
+
+	m := make(Map, len(man))
+	for p, e := range man {
+		m[p] = e.HashedPath
+	}
+
+	return m
+}
+
+// SRI returns the [SRIMap] of the original file paths to the Subresource
+// Integrity strings of the files.
+//
+// If man is nil, SRI returns nil.
+func (man Manifest) SRI() SRIMap {
+	if man == nil {
+		return nil
+	}
+
+	m := make(SRIMap, len(man))
+	for p, e := range man {
+		m[p] = e.Integrity
+	}
+
+	return m
+}
+
+// HashManifest works like [Hash], but returns a [Manifest] instead of a
+// [Map].
+func HashManifest(inFS fs.FS, o Options) (Manifest, error) {
+	if o.SRI != "" && o.SRI.new() == nil {
+		return nil, fmt.Errorf("unsupported SRI algorithm: %q", o.SRI)
+	}
+
+	return hashFS(context.Background(), inFS, o, o.SRI)
+}
+
+// HashToDirManifest works like [HashToDir], but returns a [Manifest] instead
+// of a [Map].
+//
+// The entries of files rewritten by [Options.Rewriters] describe the
+// rewritten files.
+func HashToDirManifest(inFS fs.FS, outPath string, o Options) (Manifest, error) {
+	if o.SRI != "" && o.SRI.new() == nil {
+		return nil, fmt.Errorf("unsupported SRI algorithm: %q", o.SRI)
+	}
+
+	return hashFSToDir(context.Background(), inFS, outPath, o, o.SRI)
+}
+
+// WrapFSManifest works like [WrapFS], but returns a [Manifest] instead of a
+// [Map].
+func WrapFSManifest(filesys fs.FS, o Options) (*FSWrapper, Manifest, error) {
+	o.setDefaults()
+
+	man, err := HashManifest(filesys, o)
+	if man == nil {
+		return nil, nil, err
+	}
+
+	fsw := NewFSWrapper(filesys, man.Map())
+	fsw.naming = o.Naming
+
+	return fsw, man, err
+}
+
+// ============================================================================
+// Utils
+// ======================================================================================
+
+// headWriter is an [io.Writer] that counts the bytes written to it and keeps
+// the first 512 of them, as needed by [http.DetectContentType].
+type headWriter struct {
+	head []byte
+	n    int64
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if rem := 512 - len(w.head); rem > 0 {
+		if len(p) < rem {
+			rem = len(p)
+		}
+
+		w.head = append(w.head, p[:rem]...)
+	}
+
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// contentType returns the MIME type of the file at p, that starts with head.
+func contentType(p string, head []byte) string {
+	if typ := mime.TypeByExtension(path.Ext(p)); typ != "" {
+		return typ
+	}
+
+	return http.DetectContentType(head)
+}
@@ -0,0 +1,306 @@
+package hashets
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// ManifestFormat is the format of a JSON manifest.
+type ManifestFormat uint8
+
+const (
+	// FormatHashets is hashets' own format.
+	// It contains all information of a [Manifest]:
+	//
+	//	{
+	//	  "version": 1,
+	//	  "files": {
+	//	    "foo.txt": {
+	//	      "path": "foo_1234.txt",
+	//	      "hash": "1234",
+	//	      "digest": "EjQ=",
+	//	      "integrity": "sha384-...",
+	//	      "size": 3,
+	//	      "contentType": "text/plain; charset=utf-8",
+	//	      "mode": 420,
+	//	      "variants": [
+	//	        {"encoding": "gzip", "path": "foo_1234.txt.gz", "size": 27}
+	//	      ]
+	//	    }
+	//	  }
+	//	}
+	//
+	// Fields that are unknown, such as all fields except "path" when
+	// writing a [Map], are omitted.
+	// Entries of ignored files added because of [Options.PassThrough]
+	// additionally have "unhashed": true.
+	FormatHashets ManifestFormat = iota
+	// FormatVite is the format of the manifest.json generated by Vite:
+	//
+	//	{
+	//	  "foo.txt": {
+	//	    "file": "foo_1234.txt",
+	//	    "src": "foo.txt"
+	//	  }
+	//	}
+	FormatVite
+	// FormatWebpack is the format of the assets-manifest.json generated by
+	// webpack-assets-manifest:
+	//
+	//	{
+	//	  "foo.txt": "foo_1234.txt"
+	//	}
+	FormatWebpack
+)
+
+// hashetsManifestVersion is the current version of the FormatHashets schema.
+const hashetsManifestVersion = 1
+
+var (
+	_ fmt.Stringer             = FormatHashets
+	_ encoding.TextMarshaler   = FormatHashets
+	_ encoding.TextUnmarshaler = (*ManifestFormat)(nil)
+)
+
+func (f ManifestFormat) String() string {
+	switch f {
+	case FormatHashets:
+		return "hashets"
+	case FormatVite:
+		return "vite"
+	case FormatWebpack:
+		return "webpack"
+	default:
+		return fmt.Sprintf("ManifestFormat(%d)", uint8(f))
+	}
+}
+
+// MarshalText returns the name of the format, as returned by
+// [ManifestFormat.String].
+func (f ManifestFormat) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// UnmarshalText parses the name of a format, as returned by
+// [ManifestFormat.String].
+func (f *ManifestFormat) UnmarshalText(text []byte) error {
+	for _, candidate := range []ManifestFormat{FormatHashets, FormatVite, FormatWebpack} {
+		if string(text) == candidate.String() {
+			*f = candidate
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown manifest format: %q", text)
+}
+
+type (
+	hashetsManifest struct {
+		Version int                     `json:"version"`
+		Files   map[string]hashetsEntry `json:"files"`
+	}
+
+	hashetsEntry struct {
+		Path        string      `json:"path"`
+		Hash        string      `json:"hash,omitempty"`
+		Digest      []byte      `json:"digest,omitempty"`
+		Integrity   string      `json:"integrity,omitempty"`
+		Size        int64       `json:"size,omitempty"`
+		ContentType string      `json:"contentType,omitempty"`
+		Mode        fs.FileMode `json:"mode,omitempty"`
+
+		Variants []hashetsVariant `json:"variants,omitempty"`
+		Unhashed bool             `json:"unhashed,omitempty"`
+	}
+
+	hashetsVariant struct {
+		Encoding string `json:"encoding"`
+		Path     string `json:"path"`
+		Size     int64  `json:"size"`
+	}
+
+	viteChunk struct {
+		File string `json:"file"`
+		Src  string `json:"src,omitempty"`
+	}
+
+	// webpackAsset is an asset of a manifest generated by
+	// webpack-assets-manifest with the integrity option.
+	webpackAsset struct {
+		Src       string `json:"src"`
+		Integrity string `json:"integrity"`
+	}
+)
+
+// WriteJSON writes man as JSON manifest in the passed format to w.
+func (man Manifest) WriteJSON(w io.Writer, f ManifestFormat) error {
+	var v any
+
+	switch f {
+	case FormatHashets:
+		files := make(map[string]hashetsEntry, len(man))
+		for p, e := range man {
+			je := hashetsEntry{
+				Path:        e.HashedPath,
+				Hash:        e.Hash,
+				Digest:      e.Digest,
+				Integrity:   e.Integrity,
+				Size:        e.Size,
+				ContentType: e.ContentType,
+				Mode:        e.Mode,
+				Unhashed:    e.Unhashed,
+			}
+
+			for _, variant := range e.Variants {
+				je.Variants = append(je.Variants, hashetsVariant(variant))
+			}
+
+			files[p] = je
+		}
+
+		v = hashetsManifest{Version: hashetsManifestVersion, Files: files}
+	case FormatVite:
+		chunks := make(map[string]viteChunk, len(man))
+		for p, e := range man {
+			chunks[p] = viteChunk{File: e.HashedPath, Src: p}
+		}
+
+		v = chunks
+	case FormatWebpack:
+		m := man.Map()
+		if m == nil {
+			m = Map{}
+		}
+
+		v = m
+	default:
+		return fmt.Errorf("unknown manifest format: %s", f)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// WriteJSON writes m as JSON manifest in the passed format to w.
+func (m Map) WriteJSON(w io.Writer, f ManifestFormat) error {
+	man := make(Manifest, len(m))
+	for p, hashed := range m {
+		man[p] = Entry{Path: p, HashedPath: hashed}
+	}
+
+	return man.WriteJSON(w, f)
+}
+
+// ReadManifest reads a JSON manifest in the passed format from r.
+//
+// Entries read from a [FormatVite] or [FormatWebpack] manifest only contain
+// the original and hashed path, and, for webpack manifests generated with
+// webpack-assets-manifest's integrity option, the SRI string of the file.
+func ReadManifest(r io.Reader, f ManifestFormat) (Manifest, error) {
+	dec := json.NewDecoder(r)
+
+	switch f {
+	case FormatHashets:
+		var jm hashetsManifest
+		if err := dec.Decode(&jm); err != nil {
+			return nil, err
+		}
+
+		if jm.Version < 1 || jm.Version > hashetsManifestVersion {
+			return nil, fmt.Errorf("unsupported hashets manifest version: %d", jm.Version)
+		}
+
+		man := make(Manifest, len(jm.Files))
+		for p, e := range jm.Files {
+			me := Entry{
+				Path:        p,
+				HashedPath:  e.Path,
+				Digest:      e.Digest,
+				Hash:        e.Hash,
+				Integrity:   e.Integrity,
+				Size:        e.Size,
+				ContentType: e.ContentType,
+				Mode:        e.Mode,
+				Unhashed:    e.Unhashed,
+			}
+
+			for _, variant := range e.Variants {
+				me.Variants = append(me.Variants, Variant(variant))
+			}
+
+			man[p] = me
+		}
+
+		return man, nil
+	case FormatVite:
+		var chunks map[string]viteChunk
+		if err := dec.Decode(&chunks); err != nil {
+			return nil, err
+		}
+
+		man := make(Manifest, len(chunks))
+		for p, c := range chunks {
+			man[p] = Entry{Path: p, HashedPath: c.File}
+		}
+
+		return man, nil
+	case FormatWebpack:
+		var assets map[string]json.RawMessage
+		if err := dec.Decode(&assets); err != nil {
+			return nil, err
+		}
+
+		man := make(Manifest, len(assets))
+		for p, raw := range assets {
+			var hashed string
+			if err := json.Unmarshal(raw, &hashed); err == nil {
+				man[p] = Entry{Path: p, HashedPath: hashed}
+				continue
+			}
+
+			var a webpackAsset
+			if err := json.Unmarshal(raw, &a); err != nil {
+				return nil, fmt.Errorf("invalid webpack asset %q: %w", p, err)
+			}
+
+			man[p] = Entry{Path: p, HashedPath: a.Src, Integrity: a.Integrity}
+		}
+
+		return man, nil
+	default:
+		return nil, fmt.Errorf("unknown manifest format: %s", f)
+	}
+}
+
+// ReadMap works like [ReadManifest], but returns a [Map] instead of a
+// [Manifest].
+//
+// It can be used to load the manifests of assets hashed by other tools, so
+// that they can be used alongside files hashed by hashets, e.g. by using
+// [Merge].
+func ReadMap(r io.Reader, f ManifestFormat) (Map, error) {
+	man, err := ReadManifest(r, f)
+	return man.Map(), err
+}
+
+// ReadMapFile works like [ReadMap], but reads the manifest from the file with
+// the passed name in filesys.
+func ReadMapFile(filesys fs.FS, name string, f ManifestFormat) (Map, error) {
+	file, err := filesys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := ReadMap(file, f)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return m, file.Close()
+}
@@ -0,0 +1,183 @@
+package hashets
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest_WriteJSON(t *testing.T) {
+	t.Parallel()
+
+	man := Manifest{
+		"foo.txt": {
+			Path:        "foo.txt",
+			HashedPath:  "foo_1234.txt",
+			Digest:      []byte{0x12, 0x34},
+			Hash:        "1234",
+			Integrity:   "sha256-abc",
+			Size:        3,
+			ContentType: "text/plain; charset=utf-8",
+			Mode:        0o644,
+		},
+	}
+
+	testCases := []struct {
+		name   string
+		format ManifestFormat
+		expect string
+	}{
+		{
+			name:   "hashets",
+			format: FormatHashets,
+			expect: `{"version":1,"files":{"foo.txt":{"path":"foo_1234.txt","hash":"1234","digest":"EjQ=",` +
+				`"integrity":"sha256-abc","size":3,"contentType":"text/plain; charset=utf-8","mode":420}}}`,
+		},
+		{
+			name:   "vite",
+			format: FormatVite,
+			expect: `{"foo.txt":{"file":"foo_1234.txt","src":"foo.txt"}}`,
+		},
+		{
+			name:   "webpack",
+			format: FormatWebpack,
+			expect: `{"foo.txt":"foo_1234.txt"}`,
+		},
+	}
+
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			require.NoError(t, man.WriteJSON(&buf, c.format))
+			assert.JSONEq(t, c.expect, buf.String())
+		})
+	}
+
+	t.Run("unknown format", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		assert.Error(t, man.WriteJSON(&buf, ManifestFormat(42)))
+	})
+}
+
+func TestMap_WriteJSON(t *testing.T) {
+	t.Parallel()
+
+	m := Map{"a&b.js": "a&b_1234.js"}
+
+	var buf bytes.Buffer
+	require.NoError(t, m.WriteJSON(&buf, FormatHashets))
+	assert.JSONEq(t, `{"version":1,"files":{"a&b.js":{"path":"a&b_1234.js"}}}`, buf.String())
+	assert.Contains(t, buf.String(), "a&b", "HTML characters should not be escaped")
+
+	buf.Reset()
+	require.NoError(t, Map(nil).WriteJSON(&buf, FormatWebpack))
+	assert.JSONEq(t, `{}`, buf.String())
+}
+
+func TestManifestFormat_UnmarshalText(t *testing.T) {
+	t.Parallel()
+
+	for _, f := range []ManifestFormat{FormatHashets, FormatVite, FormatWebpack} {
+		text, err := f.MarshalText()
+		require.NoError(t, err)
+
+		var actual ManifestFormat
+		require.NoError(t, actual.UnmarshalText(text))
+		assert.Equal(t, f, actual)
+	}
+
+	var f ManifestFormat
+	assert.Error(t, f.UnmarshalText([]byte("rollup")))
+}
+
+func TestReadManifest(t *testing.T) {
+	t.Parallel()
+
+	t.Run("hashets", func(t *testing.T) {
+		t.Parallel()
+
+		expect, err := HashManifest(testdataIn, Options{
+			SRI:         SRISHA256,
+			Ignore:      IgnorePrefix("foo"),
+			PassThrough: true,
+		})
+		require.NoError(t, err)
+		require.True(t, expect["foo"].Unhashed)
+
+		var buf bytes.Buffer
+		require.NoError(t, expect.WriteJSON(&buf, FormatHashets))
+
+		actual, err := ReadManifest(&buf, FormatHashets)
+		require.NoError(t, err)
+		assert.Equal(t, expect, actual)
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := ReadManifest(strings.NewReader(`{"version":2,"files":{}}`), FormatHashets)
+		assert.Error(t, err)
+	})
+
+	t.Run("vite", func(t *testing.T) {
+		t.Parallel()
+
+		const manifest = `{
+  "src/main.ts": {
+    "file": "assets/main-4f2a1c.js",
+    "src": "src/main.ts",
+    "isEntry": true,
+    "css": ["assets/main-9b8c7d.css"]
+  },
+  "src/logo.svg": {
+    "file": "assets/logo-1a2b3c.svg",
+    "src": "src/logo.svg"
+  }
+}`
+
+		actual, err := ReadMap(strings.NewReader(manifest), FormatVite)
+		require.NoError(t, err)
+		assert.Equal(t, Map{
+			"src/main.ts":  "assets/main-4f2a1c.js",
+			"src/logo.svg": "assets/logo-1a2b3c.svg",
+		}, actual)
+	})
+
+	t.Run("webpack", func(t *testing.T) {
+		t.Parallel()
+
+		const manifest = `{
+  "main.js": "main.4f2a1c.js",
+  "main.css": {"src": "main.9b8c7d.css", "integrity": "sha256-abc"}
+}`
+
+		actual, err := ReadManifest(strings.NewReader(manifest), FormatWebpack)
+		require.NoError(t, err)
+		assert.Equal(t, Manifest{
+			"main.js":  {Path: "main.js", HashedPath: "main.4f2a1c.js"},
+			"main.css": {Path: "main.css", HashedPath: "main.9b8c7d.css", Integrity: "sha256-abc"},
+		}, actual)
+	})
+}
+
+func TestReadMapFile(t *testing.T) {
+	t.Parallel()
+
+	filesys := fstest.MapFS{"manifest.json": {Data: []byte(`{"a.js": "a.1234.js"}`)}}
+
+	actual, err := ReadMapFile(filesys, "manifest.json", FormatWebpack)
+	require.NoError(t, err)
+	assert.Equal(t, Map{"a.js": "a.1234.js"}, actual)
+
+	_, err = ReadMapFile(filesys, "manifest.json", FormatVite)
+	assert.Error(t, err)
+}
@@ -0,0 +1,100 @@
+package hashets
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashManifest(t *testing.T) {
+	t.Parallel()
+
+	man, err := HashManifest(testdataIn, Options{SRI: SRISHA384})
+	require.NoError(t, err)
+
+	assert.Equal(t, expectMap, man.Map())
+
+	e := man["foo"]
+	assert.Equal(t, "foo", e.Path)
+	assert.Equal(t, expectMap["foo"], e.HashedPath)
+	assert.Equal(t, "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9", e.Hash)
+	assert.Equal(t, e.Hash, hex.EncodeToString(e.Digest))
+	assert.Equal(t, "sha384-FJGar/DaXv64cf6KQ4BhwZluiL/hmeJ5aztcXGVxT2EYOtxT1Iw6MnNMpvr31/2o", e.Integrity)
+	assert.Equal(t, int64(3), e.Size)
+	assert.Equal(t, "text/plain; charset=utf-8", e.ContentType)
+	assert.True(t, e.Mode.IsRegular())
+
+	assert.Equal(t, "image/webp", man["folder/maja.webp"].ContentType)
+	assert.Equal(t, int64(52727), man["bee movie.txt"].Size)
+}
+
+func TestHashToDirManifest(t *testing.T) {
+	t.Parallel()
+
+	inFS := fstest.MapFS{
+		"x.woff2":  {Data: []byte("font"), Mode: 0o444},
+		"site.css": {Data: []byte(`a { src: url(x.woff2) }`), Mode: 0o444},
+	}
+
+	man, err := HashToDirManifest(inFS, t.TempDir(), Options{Rewriters: []Rewriter{CSSRewriter{}}, SRI: SRISHA256})
+	require.NoError(t, err)
+
+	e := man["site.css"]
+	assert.Equal(t, int64(len(`a { src: url() }`)+len(man["x.woff2"].HashedPath)), e.Size)
+	assert.True(t, strings.HasPrefix(e.ContentType, "text/css"))
+	assert.Equal(t, SRIMap{"x.woff2": man["x.woff2"].Integrity, "site.css": e.Integrity}, man.SRI())
+}
+
+func TestWrapFSManifest(t *testing.T) {
+	t.Parallel()
+
+	fsw, man, err := WrapFSManifest(testdataIn, Options{})
+	require.NoError(t, err)
+
+	assert.Equal(t, expectMap, man.Map())
+	for _, e := range man {
+		assert.Empty(t, e.Integrity)
+		assert.True(t, fsw.IsHashed(e.HashedPath))
+	}
+}
+
+func TestHashManifest_PassThrough(t *testing.T) {
+	t.Parallel()
+
+	o := Options{Ignore: IgnorePrefix("bee movie.txt"), PassThrough: true}
+
+	man, err := HashManifest(testdataIn, o)
+	require.NoError(t, err)
+
+	assert.Equal(t, Entry{
+		Path:        "bee movie.txt",
+		HashedPath:  "bee movie.txt",
+		Size:        52727,
+		ContentType: "text/plain; charset=utf-8",
+		Mode:        man["bee movie.txt"].Mode,
+		Unhashed:    true,
+	}, man["bee movie.txt"])
+	assert.False(t, man["foo"].Unhashed)
+
+	fsw := NewFSWrapper(testdataIn, man.Map())
+	assert.False(t, fsw.IsHashed("bee movie.txt"))
+
+	h := NewHandler(fsw, HandlerOptions{Unhashed: ActionRedirect})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/bee%20movie.txt", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, NoCacheControl, rec.Header().Get("Cache-Control"))
+
+	// without PassThrough, ignored files are not part of the manifest
+	o.PassThrough = false
+	man, err = HashManifest(testdataIn, o)
+	require.NoError(t, err)
+	assert.NotContains(t, man, "bee movie.txt")
+}
@@ -0,0 +1,364 @@
+package hashets
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// NamingScheme determines the paths of hashed files.
+//
+// Unlike [Options.NamingFunc], a NamingScheme is reversible, so that the
+// original path and the hash can be recovered from a hashed path without a
+// [Map], e.g. to find out which file an outdated hashed path refers to.
+type NamingScheme interface {
+	// Format returns the hashed path of the file at p, given its textual
+	// hash, as returned by [Options.HashToText].
+	Format(p, hash string) string
+	// Parse is the inverse of Format.
+	// It returns the path of the original file and the hash, or false, if
+	// p is not a hashed path.
+	//
+	// If the original path cannot be recovered from p, e.g. for
+	// [ContentAddressedNaming], origPath is empty.
+	Parse(p string) (origPath, hash string, ok bool)
+}
+
+var (
+	_ NamingScheme = UnderscoreNaming{}
+	_ NamingScheme = DotNaming{}
+	_ NamingScheme = DirNaming{}
+	_ NamingScheme = QueryNaming{}
+	_ NamingScheme = ContentAddressedNaming{}
+	_ NamingScheme = MatchNaming{}
+)
+
+// UnderscoreNaming is the default [NamingScheme], which inserts the hash
+// before the extension of the file name, separated by an underscore.
+//
+// For a file "dir/foo.txt" with the hash "1234", it generates
+// "dir/foo_1234.txt".
+//
+// Its Parse method cannot distinguish hashed file names from file names that
+// merely look like ones, such as "foo_bar.txt".
+type UnderscoreNaming struct{}
+
+func (UnderscoreNaming) Format(p, hash string) string {
+	dir, name := path.Split(p)
+
+	base, ext, found := strings.Cut(name, ".")
+	if found {
+		return dir + base + "_" + hash + "." + ext
+	}
+	return dir + base + "_" + hash
+}
+
+func (UnderscoreNaming) Parse(p string) (origPath, hash string, ok bool) {
+	dir, name := path.Split(p)
+
+	base, ext, found := strings.Cut(name, ".")
+	i := strings.LastIndexByte(base, '_')
+	if i < 0 || i == len(base)-1 {
+		return "", "", false
+	}
+
+	origPath = dir + base[:i]
+	if found {
+		origPath += "." + ext
+	}
+
+	return origPath, base[i+1:], true
+}
+
+// DotNaming is a [NamingScheme] that inserts the hash before the extension of
+// the file name, separated by a dot.
+//
+// For a file "dir/foo.txt" with the hash "1234", it generates
+// "dir/foo.1234.txt".
+//
+// Its Parse method cannot distinguish hashed file names from file names that
+// merely look like ones, such as "foo.min.js".
+type DotNaming struct{}
+
+func (DotNaming) Format(p, hash string) string {
+	dir, name := path.Split(p)
+
+	base, ext, found := strings.Cut(name, ".")
+	if found {
+		return dir + base + "." + hash + "." + ext
+	}
+	return dir + base + "." + hash
+}
+
+func (DotNaming) Parse(p string) (origPath, hash string, ok bool) {
+	dir, name := path.Split(p)
+
+	base, rest, found := strings.Cut(name, ".")
+	if !found {
+		return "", "", false
+	}
+
+	hash, ext, found := strings.Cut(rest, ".")
+	if hash == "" || (base == "" && !found) {
+		return "", "", false
+	}
+
+	origPath = dir + base
+	if found {
+		origPath += "." + ext
+	}
+
+	return origPath, hash, true
+}
+
+// DirNaming is a [NamingScheme] that places hashed files in a directory named
+// after their hash.
+//
+// For a file "dir/foo.txt" with the hash "1234", it generates
+// "dir/1234/foo.txt", or, if Root is set, "1234/dir/foo.txt".
+//
+// Its Parse method cannot distinguish hashed paths from paths that merely
+// look like ones, i.e. all paths with at least one directory are parsed.
+type DirNaming struct {
+	// Root, if set, makes the hash directory the first segment of the hashed
+	// path, instead of the last directory.
+	Root bool
+}
+
+func (n DirNaming) Format(p, hash string) string {
+	if n.Root {
+		return hash + "/" + p
+	}
+
+	dir, name := path.Split(p)
+	return dir + hash + "/" + name
+}
+
+func (n DirNaming) Parse(p string) (origPath, hash string, ok bool) {
+	if n.Root {
+		hash, origPath, ok = strings.Cut(p, "/")
+		if !ok || hash == "" || origPath == "" {
+			return "", "", false
+		}
+
+		return origPath, hash, true
+	}
+
+	dir, name := path.Split(p)
+	if dir == "" || name == "" {
+		return "", "", false
+	}
+
+	dir = dir[:len(dir)-1]
+
+	i := strings.LastIndexByte(dir, '/')
+	return dir[:i+1] + name, dir[i+1:], true
+}
+
+// QueryNaming is a [NamingScheme] that leaves the path of a file as is, and
+// adds the hash as query parameter, so that it can be used for files whose
+// names cannot be changed.
+//
+// For a file "dir/foo.txt" with the hash "1234", it generates
+// "dir/foo.txt?v=1234".
+//
+// The Hash* functions use the path without the query as path of the hashed
+// file, and [Handler] serves the file with [HandlerOptions.HashedCacheControl]
+// only if the query matches.
+// Since rewriting such a file would overwrite its original, [HashToDir]
+// returns an error for rewritten files, if it hashes a directory in place.
+//
+// To use QueryNaming only for some files, e.g. "favicon.ico", use
+// [MatchNaming].
+type QueryNaming struct {
+	// Param is the name of the query parameter.
+	//
+	// Defaults to "v".
+	Param string
+}
+
+func (n QueryNaming) param() string {
+	if n.Param == "" {
+		return "v"
+	}
+
+	return n.Param
+}
+
+func (n QueryNaming) Format(p, hash string) string {
+	return p + "?" + url.Values{n.param(): {hash}}.Encode()
+}
+
+func (n QueryNaming) Parse(p string) (origPath, hash string, ok bool) {
+	origPath, rawQuery, found := strings.Cut(p, "?")
+	if !found {
+		return "", "", false
+	}
+
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", "", false
+	}
+
+	hash = q.Get(n.param())
+	if hash == "" {
+		return "", "", false
+	}
+
+	return origPath, hash, true
+}
+
+// ContentAddressedNaming is a [NamingScheme] that restructures the hashed
+// files into a flat content-addressed tree, in which the path of a file only
+// depends on its hash and extension.
+//
+// For a file "dir/foo.txt" with the hash "1234", it generates "12/34.txt".
+//
+// Since the original path cannot be recovered from a hashed path, its Parse
+// method always returns an empty origPath.
+// Hashed files whose originals were removed, e.g. using the -replace flag of
+// the hashets command, are therefore not recognized as such by [HashToDir].
+//
+// Files with the same contents and extension share the same hashed file.
+type ContentAddressedNaming struct {
+	// PrefixLen is the number of leading characters of the hash, that form
+	// the name of the directory the file is placed in.
+	//
+	// Defaults to 2.
+	PrefixLen int
+}
+
+func (n ContentAddressedNaming) prefixLen() int {
+	if n.PrefixLen <= 0 {
+		return 2
+	}
+
+	return n.PrefixLen
+}
+
+func (n ContentAddressedNaming) Format(p, hash string) string {
+	ext := path.Ext(p)
+	if len(hash) <= n.prefixLen() {
+		return hash + ext
+	}
+
+	return hash[:n.prefixLen()] + "/" + hash[n.prefixLen():] + ext
+}
+
+func (n ContentAddressedNaming) Parse(p string) (origPath, hash string, ok bool) {
+	dir, name := path.Split(p)
+	if len(dir) != n.prefixLen()+1 || strings.Contains(dir[:len(dir)-1], "/") {
+		return "", "", false
+	}
+
+	rest, _, _ := strings.Cut(name, ".")
+	if rest == "" {
+		return "", "", false
+	}
+
+	return "", dir[:len(dir)-1] + rest, true
+}
+
+// MatchNaming is a [NamingScheme] that uses Matched for the files matched by
+// Match, and Default for all other files.
+//
+// For example, the following scheme hashes "favicon.ico" as
+// "favicon.ico?v=1234", and "foo.txt" as "foo_1234.txt":
+//
+//	MatchNaming{
+//		Match:   func(p string) bool { return p == "favicon.ico" },
+//		Matched: QueryNaming{},
+//	}
+type MatchNaming struct {
+	// Match reports whether the file at the passed path is named using
+	// Matched.
+	Match func(p string) bool
+	// Matched is the naming scheme used for the files matched by Match.
+	Matched NamingScheme
+	// Default is the naming scheme used for all other files.
+	//
+	// Defaults to [UnderscoreNaming].
+	Default NamingScheme
+}
+
+func (n MatchNaming) defaultScheme() NamingScheme {
+	if n.Default == nil {
+		return UnderscoreNaming{}
+	}
+
+	return n.Default
+}
+
+func (n MatchNaming) Format(p, hash string) string {
+	if n.Match(p) {
+		return n.Matched.Format(p, hash)
+	}
+
+	return n.defaultScheme().Format(p, hash)
+}
+
+// Parse parses p using Matched, if the original path is matched by Match,
+// and using Default otherwise.
+func (n MatchNaming) Parse(p string) (origPath, hash string, ok bool) {
+	if origPath, hash, ok := n.Matched.Parse(p); ok && n.Match(origPath) {
+		return origPath, hash, true
+	}
+
+	origPath, hash, ok = n.defaultScheme().Parse(p)
+	if !ok || (origPath != "" && n.Match(origPath)) {
+		return "", "", false
+	}
+
+	return origPath, hash, true
+}
+
+// namingFuncScheme is the [NamingScheme] of an [Options.NamingFunc].
+type namingFuncScheme struct {
+	f func(name, hash string) string
+	// hashLen is the length of the textual hashes.
+	hashLen int
+}
+
+func (n namingFuncScheme) Format(p, hash string) string {
+	dir, name := path.Split(p)
+	return dir + n.f(name, hash)
+}
+
+// Parse reports whether the name of the file at p may have been generated by
+// n.f, by trying all substrings of the name with the length of a hash.
+func (n namingFuncScheme) Parse(p string) (origPath, hash string, ok bool) {
+	if n.hashLen == 0 {
+		return "", "", false
+	}
+
+	dir, name := path.Split(p)
+
+	for i := 0; i+n.hashLen <= len(name); i++ {
+		hash = name[i : i+n.hashLen]
+
+		// the hash is usually separated from the rest of the name, e.g. by an
+		// underscore
+		candidates := [3]string{name[:i] + name[i+n.hashLen:]}
+		if i > 0 {
+			candidates[1] = name[:i-1] + name[i+n.hashLen:]
+		}
+		if i+n.hashLen < len(name) {
+			candidates[2] = name[:i] + name[i+n.hashLen+1:]
+		}
+
+		for _, c := range candidates {
+			if c != "" && n.f(c, hash) == name {
+				return dir + c, hash, true
+			}
+		}
+	}
+
+	return "", "", false
+}
+
+// filePath returns the path of the file with the passed hashed path, i.e. the
+// hashed path without a query, as generated by [QueryNaming].
+func filePath(hashedPath string) string {
+	p, _, _ := strings.Cut(hashedPath, "?")
+	return p
+}
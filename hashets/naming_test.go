@@ -0,0 +1,339 @@
+package hashets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// matchFavicon is the Match function of the MatchNaming used in tests.
+func matchFavicon(p string) bool { return path.Base(p) == "favicon.ico" }
+
+func TestNamingScheme(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		scheme NamingScheme
+		path   string
+		expect string
+		// expectOrig is the expected original path, if it is not path.
+		expectOrig *string
+	}{
+		{name: "underscore", scheme: UnderscoreNaming{}, path: "dir/foo.txt", expect: "dir/foo_1234.txt"},
+		{name: "underscore no ext", scheme: UnderscoreNaming{}, path: "foo", expect: "foo_1234"},
+		{name: "underscore in name", scheme: UnderscoreNaming{}, path: "foo_bar.a.b", expect: "foo_bar_1234.a.b"},
+		{name: "underscore dotfile", scheme: UnderscoreNaming{}, path: ".gitignore", expect: "_1234.gitignore"},
+		{name: "dot", scheme: DotNaming{}, path: "dir/foo.txt", expect: "dir/foo.1234.txt"},
+		{name: "dot multiple exts", scheme: DotNaming{}, path: "foo.min.js", expect: "foo.1234.min.js"},
+		{name: "dot no ext", scheme: DotNaming{}, path: "foo", expect: "foo.1234"},
+		{name: "dot dotfile", scheme: DotNaming{}, path: ".gitignore", expect: ".1234.gitignore"},
+		{name: "dir", scheme: DirNaming{}, path: "dir/foo.txt", expect: "dir/1234/foo.txt"},
+		{name: "dir root", scheme: DirNaming{}, path: "foo.txt", expect: "1234/foo.txt"},
+		{name: "root dir", scheme: DirNaming{Root: true}, path: "dir/foo.txt", expect: "1234/dir/foo.txt"},
+		{
+			name:       "content-addressed",
+			scheme:     ContentAddressedNaming{},
+			path:       "dir/foo.min.js",
+			expect:     "12/34.js",
+			expectOrig: new(string),
+		},
+		{
+			name:       "content-addressed prefix len",
+			scheme:     ContentAddressedNaming{PrefixLen: 1},
+			path:       "foo",
+			expect:     "1/234",
+			expectOrig: new(string),
+		},
+		{name: "query", scheme: QueryNaming{}, path: "dir/foo.txt", expect: "dir/foo.txt?v=1234"},
+		{name: "query param", scheme: QueryNaming{Param: "h"}, path: "foo.txt", expect: "foo.txt?h=1234"},
+		{
+			name:   "match",
+			scheme: MatchNaming{Match: matchFavicon, Matched: QueryNaming{}},
+			path:   "favicon.ico",
+			expect: "favicon.ico?v=1234",
+		},
+		{
+			name:   "match default",
+			scheme: MatchNaming{Match: matchFavicon, Matched: QueryNaming{}, Default: DotNaming{}},
+			path:   "foo.txt",
+			expect: "foo.1234.txt",
+		},
+		{
+			name:   "naming func",
+			scheme: namingFuncScheme{f: func(name, hash string) string { return hash + "-" + name }, hashLen: 4},
+			path:   "dir/foo.txt",
+			expect: "dir/1234-foo.txt",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual := c.scheme.Format(c.path, "1234")
+			assert.Equal(t, c.expect, actual)
+
+			expectOrig := c.path
+			if c.expectOrig != nil {
+				expectOrig = *c.expectOrig
+			}
+
+			orig, hash, ok := c.scheme.Parse(actual)
+			assert.True(t, ok)
+			assert.Equal(t, expectOrig, orig)
+			assert.Equal(t, "1234", hash)
+		})
+	}
+}
+
+func TestNamingScheme_ParseInvalid(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		scheme NamingScheme
+		path   string
+	}{
+		{name: "underscore", scheme: UnderscoreNaming{}, path: "foo.txt"},
+		{name: "underscore empty hash", scheme: UnderscoreNaming{}, path: "foo_.txt"},
+		{name: "underscore in dir", scheme: UnderscoreNaming{}, path: "dir_1234/foo"},
+		{name: "dot", scheme: DotNaming{}, path: "foo"},
+		{name: "dot empty hash", scheme: DotNaming{}, path: "foo..txt"},
+		{name: "dot dotfile", scheme: DotNaming{}, path: ".gitignore"},
+		{name: "dir", scheme: DirNaming{}, path: "foo.txt"},
+		{name: "root dir", scheme: DirNaming{Root: true}, path: "foo.txt"},
+		{name: "content-addressed", scheme: ContentAddressedNaming{}, path: "dir/foo/1234.txt"},
+		{name: "query", scheme: QueryNaming{}, path: "foo.txt"},
+		{name: "query other param", scheme: QueryNaming{}, path: "foo.txt?h=1234"},
+		{
+			name:   "match default of matched",
+			scheme: MatchNaming{Match: matchFavicon, Matched: QueryNaming{}},
+			path:   "favicon_1234.ico",
+		},
+		{
+			name:   "match matched of unmatched",
+			scheme: MatchNaming{Match: matchFavicon, Matched: QueryNaming{}},
+			path:   "foo.txt?v=1234",
+		},
+		{
+			name:   "naming func",
+			scheme: namingFuncScheme{f: func(name, hash string) string { return hash + "-" + name }, hashLen: 4},
+			path:   "foo-1234.txt",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, _, ok := c.scheme.Parse(c.path)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestHashToDir_DirNaming(t *testing.T) {
+	t.Parallel()
+
+	files := map[string]string{"a.txt": "a", "js/b.js": "b"}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, files)
+
+	o := Options{Naming: DirNaming{}}
+
+	m, err := HashToDir(os.DirFS(dir), dir, o)
+	require.NoError(t, err)
+
+	for orig, hashed := range m {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(hashed)))
+		require.NoError(t, err)
+		assert.Equal(t, files[orig], string(data))
+		assert.Equal(t, filepath.Dir(orig), filepath.Dir(filepath.Dir(hashed)))
+	}
+
+	// the hashed files are recognized, and not hashed again
+	before := snapshotDir(t, dir)
+	rerun, err := HashToDir(os.DirFS(dir), dir, o)
+	require.NoError(t, err)
+	assert.Equal(t, m, rerun)
+	dirsEqual(t, before, os.DirFS(dir))
+
+	// after changing a file, the stale hashed file and its directory are
+	// removed
+	writeFiles(t, dir, map[string]string{"a.txt": "c"})
+
+	m2, err := HashToDir(os.DirFS(dir), dir, o)
+	require.NoError(t, err)
+
+	removed, err := RemoveStale(dir, m2, 0, o)
+	require.NoError(t, err)
+	assert.Equal(t, []string{m["a.txt"]}, removed)
+	assert.NoDirExists(t, filepath.Join(dir, filepath.Dir(m["a.txt"])))
+	assert.FileExists(t, filepath.Join(dir, filepath.FromSlash(m2["a.txt"])))
+}
+
+func TestHashToDir_ContentAddressedNaming(t *testing.T) {
+	t.Parallel()
+
+	files := map[string]string{
+		"css/a.css": "a { background: url(../img/b.png) }",
+		"img/b.png": "png",
+		"img/c.png": "png",
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, files)
+
+	o := Options{Naming: ContentAddressedNaming{}, Rewriters: []Rewriter{CSSRewriter{}}}
+
+	m, err := HashToDir(os.DirFS(dir), dir, o)
+	require.NoError(t, err)
+	require.Len(t, m, 3)
+
+	// files with the same contents share their hashed file
+	assert.Equal(t, m["img/b.png"], m["img/c.png"])
+	assert.Regexp(t, `^[0-9a-f]{2}/[0-9a-f]{62}\.png$`, m["img/b.png"])
+
+	css, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(m["css/a.css"])))
+	require.NoError(t, err)
+	assert.Equal(t, "a { background: url(../"+m["img/b.png"]+") }", string(css))
+
+	// the hashed files are recognized, and not hashed again
+	before := snapshotDir(t, dir)
+	rerun, err := HashToDir(os.DirFS(dir), dir, o)
+	require.NoError(t, err)
+	assert.Equal(t, m, rerun)
+	dirsEqual(t, before, os.DirFS(dir))
+
+	// the input directories are not mirrored
+	outDir := t.TempDir()
+	_, err = HashToDir(os.DirFS(dir), outDir, o)
+	require.NoError(t, err)
+	assert.NoDirExists(t, filepath.Join(outDir, "css"))
+}
+
+func TestHashToDir_RootDirNaming(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"css/a.css": "a { background: url(../img/b.png) }",
+		"img/b.png": "png",
+	})
+
+	o := Options{Naming: DirNaming{Root: true}, Rewriters: []Rewriter{CSSRewriter{}}}
+
+	outDir := t.TempDir()
+
+	m, err := HashToDir(os.DirFS(dir), outDir, o)
+	require.NoError(t, err)
+
+	assert.Regexp(t, `^[0-9a-f]{64}/img/b\.png$`, m["img/b.png"])
+
+	css, err := os.ReadFile(filepath.Join(outDir, filepath.FromSlash(m["css/a.css"])))
+	require.NoError(t, err)
+	assert.Equal(t, "a { background: url(../../"+m["img/b.png"]+") }", string(css))
+}
+
+func TestWrapFS_ContentAddressedNaming(t *testing.T) {
+	t.Parallel()
+
+	fsw, m, err := WrapFS(testdataIn, Options{Naming: ContentAddressedNaming{}})
+	require.NoError(t, err)
+
+	h := NewHandler(fsw, HandlerOptions{Naming: ContentAddressedNaming{}, Stale: ActionRedirect})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+m["foo"], nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, ImmutableCacheControl, rec.Header().Get("Cache-Control"))
+
+	// the original of an outdated content-addressed path is unknown
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/00/1234", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestHashToDir_QueryNaming(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"favicon.ico": "ico",
+		"css/a.css":   "a { background: url(../favicon.ico?x=1#f) }",
+	})
+
+	o := Options{
+		Naming:    MatchNaming{Match: matchFavicon, Matched: QueryNaming{}},
+		Rewriters: []Rewriter{CSSRewriter{}},
+	}
+
+	m, err := HashToDir(os.DirFS(dir), dir, o)
+	require.NoError(t, err)
+
+	assert.Regexp(t, `^favicon\.ico\?v=[0-9a-f]{64}$`, m["favicon.ico"])
+	assert.Regexp(t, `^css/a_[0-9a-f]{64}\.css$`, m["css/a.css"])
+
+	// the file is left as is
+	stat, err := os.Stat(filepath.Join(dir, "favicon.ico"))
+	require.NoError(t, err)
+	assert.NotZero(t, stat.Mode().Perm()&0o200)
+
+	_, query, _ := strings.Cut(m["favicon.ico"], "?")
+
+	css, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(m["css/a.css"])))
+	require.NoError(t, err)
+	assert.Equal(t, "a { background: url(../favicon.ico?"+query+"&x=1#f) }", string(css))
+
+	before := snapshotDir(t, dir)
+	rerun, err := HashToDir(os.DirFS(dir), dir, o)
+	require.NoError(t, err)
+	assert.Equal(t, m, rerun)
+	dirsEqual(t, before, os.DirFS(dir))
+}
+
+func TestHashToDir_QueryNamingRewriteInPlace(t *testing.T) {
+	t.Parallel()
+
+	files := map[string]string{
+		"img.svg":  "svg",
+		"site.css": "a { background: url(img.svg) }",
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, files)
+
+	o := Options{Naming: QueryNaming{}, Rewriters: []Rewriter{CSSRewriter{}}}
+
+	_, err := HashToDir(os.DirFS(dir), dir, o)
+	require.Error(t, err)
+
+	// the original is left as is
+	data, err := os.ReadFile(filepath.Join(dir, "site.css"))
+	require.NoError(t, err)
+	assert.Equal(t, files["site.css"], string(data))
+
+	stat, err := os.Stat(filepath.Join(dir, "site.css"))
+	require.NoError(t, err)
+	assert.NotZero(t, stat.Mode().Perm()&0o200)
+
+	// a separate output directory is fine
+	outDir := t.TempDir()
+	m, err := HashToDir(os.DirFS(dir), outDir, o)
+	require.NoError(t, err)
+
+	data, err = os.ReadFile(filepath.Join(outDir, "site.css"))
+	require.NoError(t, err)
+	assert.Equal(t, "a { background: url("+m["img.svg"]+") }", string(data))
+}
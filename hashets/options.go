@@ -4,25 +4,62 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"hash"
+	"path"
 	"strings"
 )
 
 // Options provides configuration options for the Hash* functions.
 type Options struct {
-	// HashFunc is the hash function to use.
+	// NewHash returns a new instance of the hash function to use.
 	//
-	// Defaults to [sha256.New].
+	// Each file is hashed using its own instance, so that files can be
+	// hashed concurrently.
+	//
+	// Defaults to [sha256.New], unless Hash is set.
+	NewHash func() hash.Hash
+	// Hash is the hash function to use, if NewHash is not set.
+	//
+	// Since a single hash.Hash cannot be used concurrently, files are hashed
+	// sequentially, if Hash is used, regardless of Concurrency.
+	// Similarly, two calls to the Hash* functions using the same Hash must
+	// not be made concurrently.
+	//
+	// Deprecated: Use NewHash instead.
 	Hash hash.Hash
 
+	// Concurrency is the maximum number of files hashed concurrently.
+	//
+	// If Concurrency is greater than 1, Naming, NamingFunc, HashToText,
+	// Extractors, and Rewriters must be safe for concurrent use.
+	// Regardless of Concurrency, the results of the Hash* functions are the
+	// same.
+	//
+	// Defaults to 1.
+	Concurrency int
+
+	// Naming is the naming scheme used to generate the paths of the hashed
+	// files, and to parse them, e.g. to recognize hashed files written by a
+	// previous run of [HashToDir].
+	//
+	// If two files end up with the same hashed path, or if the hashed path of
+	// a file is the path of another file, a [*CollisionError] is returned.
+	//
+	// Defaults to the naming scheme of NamingFunc, if it is set, or
+	// [UnderscoreNaming] otherwise.
+	Naming NamingScheme
+
 	// NamingFunc is the function used to generate the file name for the hashed
-	// file.
+	// file, if Naming is not set.
 	//
 	// For each generated file, NamingFunc is called with the original file
 	// name (not path) and the textual hash of the file, as returned by
 	// [Options.HashToText].
 	// It is expected to return a new file name containing the hash.
 	//
-	// Defaults to DefaultNamingFunc.
+	// Since NamingFunc is not reversible, hashed file names are parsed by
+	// searching them for a hash, for which NamingFunc generates the same
+	// name.
+	// Prefer Naming, which doesn't have to guess.
 	NamingFunc func(name, hash string) string
 
 	// HashToText is the function to convert the hash to a string.
@@ -30,6 +67,19 @@ type Options struct {
 	// Defaults to [hex.EncodeToString].
 	HashToText func([]byte) string
 
+	// CollectErrors, if set, makes the Hash* functions continue hashing, if
+	// a file cannot be hashed.
+	//
+	// Instead of stopping at the first error, they then return the results
+	// for the files that could be hashed, alongside a [FileErrors] listing
+	// each file that could not be hashed and why.
+	// Files referencing such a file cannot be hashed either, and are listed
+	// as well.
+	//
+	// Errors unrelated to a specific file, such as a [*CycleError], are still
+	// returned immediately.
+	CollectErrors bool
+
 	// Ignore is called for each file and, if it returns true, the file is
 	// ignored, i.e. not hashed.
 	//
@@ -37,24 +87,156 @@ type Options struct {
 	//
 	// Defaults to ignoring no files.
 	Ignore func(path string) bool
+
+	// PassThrough, if set, adds the files ignored by Ignore to the returned
+	// [Map] and [Manifest], mapped to themselves, so that [Map.Get] returns
+	// a usable path for every file.
+	// Their entries have [Entry.Unhashed] set.
+	//
+	// [HashToDir] does not copy ignored files to the output directory, so
+	// their paths refer to the files of the hashed [fs.FS].
+	PassThrough bool
+
+	// Extractors are used to extract the references a file makes to other
+	// files.
+	// The hashes of the referenced files are then folded into the hash of
+	// the referencing file, so that a file's hash changes, whenever the hash
+	// of a file it references changes.
+	//
+	// For each file, only the first ReferenceExtractor that matches it is
+	// used.
+	// Rewriters are used as ReferenceExtractors as well, but
+	// Extractors take precedence.
+	//
+	// If files reference each other in a cycle, a [*CycleError] is returned.
+	//
+	// Defaults to extracting no references.
+	Extractors []ReferenceExtractor
+
+	// Rewriters are used by [HashToDir] and [HashToTempDir] to rewrite the
+	// references a file makes to other files, before hashing it.
+	// Rewritten files are not subject to Extractors.
+	//
+	// For each file, only the first Rewriter that matches it is used.
+	//
+	// Defaults to rewriting no files.
+	Rewriters []Rewriter
+
+	// Compression, if set, makes [HashToDir] and [HashToTempDir] write
+	// precompressed variants of the hashed files alongside them, e.g.
+	// "foo_1234.txt.gz" for "foo_1234.txt".
+	//
+	// The written variants are listed in [Entry.Variants].
+	//
+	// Defaults to writing no precompressed variants.
+	Compression *CompressionOptions
+
+	// SRI is the hash algorithm used by [HashSRI] and [HashToDirSRI] to
+	// compute Subresource Integrity strings.
+	//
+	// The *Manifest functions only compute SRI strings if SRI is set.
+	//
+	// Defaults to [SRISHA384].
+	SRI SRIAlgorithm
 }
 
 func (o *Options) setDefaults() {
-	if o.Hash == nil {
-		o.Hash = sha256.New()
+	if o.NewHash == nil {
+		if o.Hash != nil {
+			h := o.Hash
+			o.NewHash = func() hash.Hash { return h }
+			o.Concurrency = 1
+		} else {
+			o.NewHash = sha256.New
+		}
 	}
 
-	if o.NamingFunc == nil {
-		o.NamingFunc = DefaultNamingFunc
+	if o.Concurrency < 1 {
+		o.Concurrency = 1
 	}
 
 	if o.HashToText == nil {
 		o.HashToText = hex.EncodeToString
 	}
 
+	if o.Naming == nil {
+		if o.NamingFunc != nil {
+			o.Naming = namingFuncScheme{f: o.NamingFunc, hashLen: o.hashLen()}
+		} else {
+			o.Naming = UnderscoreNaming{}
+		}
+	}
+
 	if o.Ignore == nil {
 		o.Ignore = func(string) bool { return false }
 	}
+
+	if o.Compression != nil {
+		co := *o.Compression
+		co.setDefaults()
+		o.Compression = &co
+	}
+}
+
+// hashLen returns the length of the textual hashes returned by o.HashToText.
+func (o *Options) hashLen() int {
+	return len(o.HashToText(o.NewHash().Sum(nil)))
+}
+
+// keepsDirs reports whether o.Naming places hashed files in the same
+// directories as their originals.
+func (o *Options) keepsDirs() bool {
+	loc := o.Naming.Format("dir/file", strings.Repeat("0", o.hashLen()))
+	return path.Dir(filePath(loc)) == "dir"
+}
+
+// parseHashedPath parses the hashed path p using o.Naming, and reports
+// whether p is a hashed path with a hash of length hashLen.
+//
+// As for [NamingScheme.Parse], origPath may be empty.
+func (o *Options) parseHashedPath(p string, hashLen int) (origPath, hash string, ok bool) {
+	origPath, hash, ok = o.Naming.Parse(p)
+	if !ok || len(hash) != hashLen {
+		return "", "", false
+	}
+
+	return origPath, hash, true
+}
+
+func (o *Options) sriAlgorithm() SRIAlgorithm {
+	if o.SRI == "" {
+		return SRISHA384
+	}
+
+	return o.SRI
+}
+
+// rewriter returns the first of o.Rewriters that matches the file at p, or nil
+// if there is none.
+func (o *Options) rewriter(p string) Rewriter {
+	for _, rw := range o.Rewriters {
+		if rw.Match(p) {
+			return rw
+		}
+	}
+
+	return nil
+}
+
+// extractor returns the first of o.Extractors and o.Rewriters that matches the
+// file at p, or nil if there is none.
+func (o *Options) extractor(p string) ReferenceExtractor {
+	for _, ext := range o.Extractors {
+		if ext.Match(p) {
+			return ext
+		}
+	}
+
+	if rw := o.rewriter(p); rw != nil {
+		return rw
+	}
+
+	return nil
 }
 
 // IgnorePrefix returns a func to be used with [Options.Ignore] that ignores
@@ -70,13 +252,10 @@ func IgnorePrefix(prefixes ...string) func(string) bool {
 	}
 }
 
-// DefaultNamingFunc is the default naming function used by [Options].
+// DefaultNamingFunc is the naming function of [UnderscoreNaming], the
+// default naming scheme used by [Options].
 //
 // For a file "foo.txt" with the hash "1234", it would generate "foo_1234.txt".
 func DefaultNamingFunc(name, hash string) string {
-	base, ext, found := strings.Cut(name, ".")
-	if found {
-		return base + "_" + hash + "." + ext
-	}
-	return base + "_" + hash
+	return UnderscoreNaming{}.Format(name, hash)
 }
@@ -0,0 +1,218 @@
+package hashets
+
+import (
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A Rewriter rewrites the references a file makes to other files, so that
+// they point to the hashed files instead.
+//
+// Rewriters are used by [HashToDir] and [HashToTempDir], which hash the files
+// matched by a Rewriter only after rewriting them.
+// That way, the hash of a file changes, whenever the hash of a file it
+// references changes.
+//
+// All other functions use Rewriters as [ReferenceExtractor].
+type Rewriter interface {
+	ReferenceExtractor
+
+	// Rewrite returns data with all references to files in m replaced by
+	// references to their hashed equivalents.
+	//
+	// If m also contains path itself, relative references must be relative
+	// to the hashed path of the file.
+	// The Hash* functions add path to m, if the naming scheme places the
+	// hashed file in another directory than the original file, using a
+	// placeholder hash.
+	Rewrite(path string, data []byte, m Map) []byte
+}
+
+// span is the position of a reference in a file's contents.
+type span struct {
+	start, end int
+}
+
+// references resolves the references at the passed spans of data, as made by
+// the file at p, and returns the paths of the referenced files.
+func references(p, prefix string, data []byte, spans []span) []string {
+	refs := make([]string, 0, len(spans))
+
+spans:
+	for _, s := range spans {
+		target, _, ok := resolveRef(p, prefix, string(data[s.start:s.end]))
+		if !ok {
+			continue
+		}
+
+		for _, ref := range refs {
+			if ref == target {
+				continue spans
+			}
+		}
+
+		refs = append(refs, target)
+	}
+
+	return refs
+}
+
+// rewrite replaces the references at the passed spans of data, as made by the
+// file at p, by references to the hashed files in m.
+func rewrite(p, prefix string, data []byte, spans []span, m Map) []byte {
+	out := make([]byte, 0, len(data))
+
+	var last int
+	for _, s := range spans {
+		ref, ok := rewriteRef(p, prefix, string(data[s.start:s.end]), m)
+		if !ok {
+			continue
+		}
+
+		out = append(out, data[last:s.start]...)
+		out = append(out, ref...)
+		last = s.end
+	}
+
+	return append(out, data[last:]...)
+}
+
+// resolveRef resolves ref, a URL referenced by the file at p, to the path of
+// the referenced file.
+//
+// Relative URLs are resolved relative to p, absolute URLs relative to prefix,
+// the URL path under which the root of the hashed [fs.FS] is served.
+//
+// suffix is the query and fragment of the URL, if any.
+// If ref does not reference a file in the [fs.FS], ok is false.
+func resolveRef(p, prefix, ref string) (target, suffix string, ok bool) {
+	if ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "//") {
+		return "", "", false
+	}
+
+	if u, err := url.Parse(ref); err != nil || u.Scheme != "" || u.Host != "" {
+		return "", "", false
+	}
+
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		ref, suffix = ref[:i], ref[i:]
+	}
+
+	ref, err := url.PathUnescape(ref)
+	if err != nil || ref == "" {
+		return "", "", false
+	}
+
+	if strings.HasPrefix(ref, "/") {
+		prefix = normalizePrefix(prefix)
+		if !strings.HasPrefix(ref, prefix) {
+			return "", "", false
+		}
+
+		target = path.Clean(ref[len(prefix):])
+	} else {
+		target = path.Join(path.Dir(p), ref)
+	}
+
+	if target == "." || target == ".." || strings.HasPrefix(target, "../") {
+		return "", "", false
+	}
+
+	return target, suffix, true
+}
+
+// rewriteRef rewrites ref, a URL referenced by the file at p, so that it
+// references the hashed file in m.
+//
+// If m contains p itself, relative references are rewritten relative to the
+// hashed path of p, as the rewritten contents are stored there.
+//
+// If ref does not reference a file in m, ok is false.
+func rewriteRef(p, prefix, ref string, m Map) (_ string, ok bool) {
+	target, suffix, ok := resolveRef(p, prefix, ref)
+	if !ok {
+		return "", false
+	}
+
+	dir := path.Dir(p)
+	if hashedP, ok := m[p]; ok {
+		dir = path.Dir(filePath(hashedP))
+	}
+
+	hashed, ok := m[target]
+	if !ok {
+		return "", false
+	}
+
+	hashed, hashedQuery, hasQuery := strings.Cut(hashed, "?")
+
+	refPath := strings.TrimSuffix(ref, suffix)
+	unescaped, _ := url.PathUnescape(refPath)
+
+	var rewritten string
+	switch {
+	case strings.HasPrefix(unescaped, "/"):
+		rewritten = normalizePrefix(prefix) + hashed
+	case dir == path.Dir(p) && path.Dir(target) == path.Dir(hashed):
+		// keep the reference as is, only replace the file name
+		rewritten = unescaped[:strings.LastIndexByte(unescaped, '/')+1] + path.Base(hashed)
+	default:
+		rel, err := filepath.Rel(filepath.FromSlash(dir), filepath.FromSlash(hashed))
+		if err != nil {
+			return "", false
+		}
+
+		rewritten = filepath.ToSlash(rel)
+	}
+
+	if unescaped != refPath {
+		rewritten = (&url.URL{Path: rewritten}).EscapedPath()
+	}
+
+	if hasQuery {
+		suffix = mergeQuery(hashedQuery, suffix)
+	}
+
+	return rewritten + suffix, true
+}
+
+// mergeQuery returns suffix, the query and fragment of a reference, with the
+// parameters of the passed query, as generated by [QueryNaming], added to it.
+func mergeQuery(query, suffix string) string {
+	rawQuery, fragment, hasFragment := strings.Cut(suffix, "#")
+	if hasFragment {
+		fragment = "#" + fragment
+	}
+
+	rawQuery = strings.TrimPrefix(rawQuery, "?")
+	if rawQuery == "" {
+		return "?" + query + fragment
+	}
+
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "?" + query + "&" + rawQuery + fragment
+	}
+
+	add, _ := url.ParseQuery(query)
+	for k, v := range add {
+		q[k] = v
+	}
+
+	return "?" + q.Encode() + fragment
+}
+
+// normalizePrefix returns prefix with a leading and a trailing slash.
+func normalizePrefix(prefix string) string {
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return prefix
+}
@@ -0,0 +1,88 @@
+package hashets
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io/fs"
+)
+
+// SRIAlgorithm is a hash algorithm supported by Subresource Integrity.
+type SRIAlgorithm string
+
+const (
+	SRISHA256 SRIAlgorithm = "sha256"
+	SRISHA384 SRIAlgorithm = "sha384"
+	SRISHA512 SRIAlgorithm = "sha512"
+)
+
+// new returns a new hash.Hash for alg, or nil if alg is not supported.
+func (alg SRIAlgorithm) new() hash.Hash {
+	switch alg {
+	case SRISHA256:
+		return sha256.New()
+	case SRISHA384:
+		return sha512.New384()
+	case SRISHA512:
+		return sha512.New()
+	default:
+		return nil
+	}
+}
+
+// encode returns the SRI string for the passed digest.
+func (alg SRIAlgorithm) encode(digest []byte) string {
+	return string(alg) + "-" + base64.StdEncoding.EncodeToString(digest)
+}
+
+// SRIMap represents a map of file paths to the Subresource Integrity strings
+// of the files, e.g. "sha384-oqVuAfXRKap7fdgcCY5uykM6+R9GqQ8K/uxy9rx7HNQlGYl1kPzQho1wx4JwY8wC".
+//
+// Its keys are the same as those of the corresponding [Map], i.e. the
+// original file paths.
+type SRIMap map[string]string
+
+// Get returns the SRI string of the file with the given path.
+//
+// If m is nil, or there is no SRI string for the file, Get returns an empty
+// string.
+// An empty integrity attribute is ignored by browsers, so that, just like
+// [Map.Get], Get can be used with unhashed files during development.
+//
+// Like [Map.Lookup], it ignores leading "/" and "./".
+func (m SRIMap) Get(name string) string {
+	key, _ := lookupKey(name)
+	return m[key]
+}
+
+// HashSRI works like [Hash], but additionally computes the Subresource
+// Integrity strings of the hashed files in the same pass, using
+// [Options.SRI].
+func HashSRI(inFS fs.FS, o Options) (Map, SRIMap, error) {
+	alg := o.sriAlgorithm()
+	if alg.new() == nil {
+		return nil, nil, fmt.Errorf("unsupported SRI algorithm: %q", alg)
+	}
+
+	man, err := hashFS(context.Background(), inFS, o, alg)
+	return man.Map(), man.SRI(), err
+}
+
+// HashToDirSRI works like [HashToDir], but additionally computes the
+// Subresource Integrity strings of the written files in the same pass, using
+// [Options.SRI].
+//
+// The SRI strings of files rewritten by [Options.Rewriters] are those of the
+// rewritten contents.
+func HashToDirSRI(inFS fs.FS, outPath string, o Options) (Map, SRIMap, error) {
+	alg := o.sriAlgorithm()
+	if alg.new() == nil {
+		return nil, nil, fmt.Errorf("unsupported SRI algorithm: %q", alg)
+	}
+
+	man, err := hashFSToDir(context.Background(), inFS, outPath, o, alg)
+	return man.Map(), man.SRI(), err
+}
@@ -0,0 +1,65 @@
+package hashets
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashSRI(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		alg    SRIAlgorithm
+		expect string
+	}{
+		{alg: "", expect: "sha384-FJGar/DaXv64cf6KQ4BhwZluiL/hmeJ5aztcXGVxT2EYOtxT1Iw6MnNMpvr31/2o"},
+		{alg: SRISHA256, expect: "sha256-/N4rLtula/QIYB+3If6bXDONEO5CnqBPrlURto+/j7k="},
+		{alg: SRISHA384, expect: "sha384-FJGar/DaXv64cf6KQ4BhwZluiL/hmeJ5aztcXGVxT2EYOtxT1Iw6MnNMpvr31/2o"},
+		{alg: SRISHA512, expect: "sha512-2CxOtSYcuciqmFXt1n0b0QSC9BUphY2SUJTRc/pmKqkf85vFsYhhUnNIQCHfsW/YKEz2hMzw/Hlb46ovwebBgQ=="},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(string(c.alg), func(t *testing.T) {
+			t.Parallel()
+
+			m, sri, err := HashSRI(testdataIn, Options{SRI: c.alg})
+			require.NoError(t, err)
+
+			assert.Equal(t, expectMap, m)
+			assert.Len(t, sri, len(expectMap))
+			assert.Equal(t, c.expect, sri.Get("foo"))
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, err := HashSRI(testdataIn, Options{SRI: "md5"})
+		assert.Error(t, err)
+	})
+}
+
+func TestHashToDirSRI(t *testing.T) {
+	t.Parallel()
+
+	inFS := fstest.MapFS{
+		"x.woff2":  {Data: []byte("font")},
+		"site.css": {Data: []byte(`a { src: url(x.woff2) }`)},
+	}
+
+	m, sri, err := HashToDirSRI(inFS, t.TempDir(), Options{Rewriters: []Rewriter{CSSRewriter{}}})
+	require.NoError(t, err)
+
+	rewritten := fstest.MapFS{"site.css": {Data: []byte(`a { src: url(` + m["x.woff2"] + `) }`)}}
+	_, expect, err := HashSRI(rewritten, Options{})
+	require.NoError(t, err)
+
+	assert.Equal(t, SRIMap{
+		"x.woff2":  "sha384-chUOaRVsSIY/6v7sE86Pop07Yx+yaCfdjCnQcEc9Mqqf23RBok9Nif7bJ8zyO1Ak",
+		"site.css": expect["site.css"],
+	}, sri)
+}